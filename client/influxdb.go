@@ -2,6 +2,9 @@ package client
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +12,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/influxdb/influxdb/influxql"
@@ -32,15 +36,44 @@ type Config struct {
 	Password  string
 	UserAgent string
 	Timeout   time.Duration
+	UnsafeSsl bool // skips verification of the server's certificate
+
+	// AuthToken is sent in an "Authorization: Token" header instead of
+	// basic auth. It can't be used together with Username and Password.
+	AuthToken string
+
+	// Headers are added to every request, such as those a gateway requires.
+	Headers map[string]string
+
+	// CompressWrites gzips the body of line protocol writes.
+	CompressWrites bool
+
+	// WriteTimeout and QueryTimeout, if set, replace Timeout for writes and
+	// for queries respectively, so that slow commands can be given longer
+	// than writes.
+	WriteTimeout time.Duration
+	QueryTimeout time.Duration
+
+	// Proxy, if set, is the proxy every request is sent through, in place
+	// of the one HTTP_PROXY or HTTPS_PROXY gives.
+	Proxy *url.URL
 }
 
 // Client is used to make calls to the server.
 type Client struct {
-	url        url.URL
-	username   string
-	password   string
-	httpClient *http.Client
-	userAgent  string
+	url            url.URL
+	username       string
+	password       string
+	authToken      string
+	headers        map[string]string
+	compressWrites bool
+	httpClient     *http.Client
+	userAgent      string
+
+	// Per-request timeouts, used instead of the http client's timeout when
+	// writes or queries have their own. Requests that are neither, such as
+	// pings and dumps, are given timeout.
+	timeout, writeTimeout, queryTimeout time.Duration
 }
 
 const (
@@ -52,12 +85,40 @@ const (
 
 // NewClient will instantiate and return a connected client to issue commands to the server.
 func NewClient(c Config) (*Client, error) {
+	if c.AuthToken != "" && (c.Username != "" || c.Password != "") {
+		return nil, errors.New("auth token can't be used with a username or password")
+	}
 	client := Client{
-		url:        c.URL,
-		username:   c.Username,
-		password:   c.Password,
-		httpClient: &http.Client{Timeout: c.Timeout},
-		userAgent:  c.UserAgent,
+		url:            c.URL,
+		username:       c.Username,
+		password:       c.Password,
+		authToken:      c.AuthToken,
+		headers:        c.Headers,
+		compressWrites: c.CompressWrites,
+		httpClient:     &http.Client{Timeout: c.Timeout},
+		userAgent:      c.UserAgent,
+	}
+	if c.WriteTimeout > 0 || c.QueryTimeout > 0 {
+		client.httpClient.Timeout = 0
+		client.timeout, client.writeTimeout, client.queryTimeout = c.Timeout, c.Timeout, c.Timeout
+		if c.WriteTimeout > 0 {
+			client.writeTimeout = c.WriteTimeout
+		}
+		if c.QueryTimeout > 0 {
+			client.queryTimeout = c.QueryTimeout
+		}
+	}
+	if c.UnsafeSsl || c.Proxy != nil {
+		// Start from the default transport so its dial, TLS handshake and
+		// idle connection timeouts still apply.
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		if c.Proxy != nil {
+			t.Proxy = http.ProxyURL(c.Proxy)
+		}
+		if c.UnsafeSsl {
+			t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+		client.httpClient.Transport = t
 	}
 	if client.userAgent == "" {
 		client.userAgent = "InfluxDBClient"
@@ -71,6 +132,34 @@ func (c *Client) SetAuth(u, p string) {
 	c.password = p
 }
 
+// setHeaders adds the client's custom headers and credentials to req. The
+// credentials are set last, so a custom header can't replace them.
+func (c *Client) setHeaders(req *http.Request) {
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+	c.authorize(req)
+}
+
+// authorize adds the client's credentials to req.
+func (c *Client) authorize(req *http.Request) {
+	if c.authToken != "" {
+		req.Header.Set("Authorization", "Token "+c.authToken)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+}
+
+// withTimeout returns req limited to taking d, if it is set. The returned
+// function must be called once the response body has been read.
+func withTimeout(req *http.Request, d time.Duration) (*http.Request, context.CancelFunc) {
+	if d <= 0 {
+		return req, func() {}
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), d)
+	return req.WithContext(ctx), cancel
+}
+
 // Query sends a command to the server and returns the Response
 func (c *Client) Query(q Query) (*Response, error) {
 	u := c.url
@@ -86,10 +175,10 @@ func (c *Client) Query(q Query) (*Response, error) {
 		return nil, err
 	}
 	req.Header.Set("User-Agent", c.userAgent)
-	if c.username != "" {
-		req.SetBasicAuth(c.username, c.password)
-	}
+	c.setHeaders(req)
 
+	req, cancel := withTimeout(req, c.queryTimeout)
+	defer cancel()
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, err
@@ -152,9 +241,7 @@ func (c *Client) Write(bp BatchPoints) (*Response, error) {
 	}
 	req.Header.Set("Content-Type", "")
 	req.Header.Set("User-Agent", c.userAgent)
-	if c.username != "" {
-		req.SetBasicAuth(c.username, c.password)
-	}
+	c.setHeaders(req)
 	params := req.URL.Query()
 	params.Add("db", bp.Database)
 	params.Add("rp", bp.RetentionPolicy)
@@ -162,6 +249,8 @@ func (c *Client) Write(bp BatchPoints) (*Response, error) {
 	params.Add("consistency", bp.WriteConsistency)
 	req.URL.RawQuery = params.Encode()
 
+	req, cancel := withTimeout(req, c.writeTimeout)
+	defer cancel()
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, err
@@ -183,6 +272,92 @@ func (c *Client) Write(bp BatchPoints) (*Response, error) {
 	return nil, nil
 }
 
+// WriteLineProtocol takes a string with line returns to delimit each write
+// If successful, error is nil and Response is nil
+// If an error occurs, Response may contain additional information if populated.
+// If the server accepts the write but reports an error in the response body,
+// such as a partial write, error is nil and the Response holds the error.
+func (c *Client) WriteLineProtocol(data, database, retentionPolicy, precision, writeConsistency string) (*Response, error) {
+	return c.writeLineProtocol(strings.NewReader(data), int64(len(data)), database, retentionPolicy, precision, writeConsistency)
+}
+
+// WriteLines is like WriteLineProtocol, but takes the lines to write as a
+// slice. They are streamed into the request body rather than joined into one
+// string first, so a large batch isn't held in memory twice.
+func (c *Client) WriteLines(lines []string, database, retentionPolicy, precision, writeConsistency string) (*Response, error) {
+	r := &linesReader{lines: lines}
+	return c.writeLineProtocol(r, r.size(), database, retentionPolicy, precision, writeConsistency)
+}
+
+// writeLineProtocol writes the size bytes of line protocol read from r.
+func (c *Client) writeLineProtocol(r io.Reader, size int64, database, retentionPolicy, precision, writeConsistency string) (*Response, error) {
+	u := c.url
+	u.Path = "write"
+
+	if c.compressWrites {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := io.Copy(gz, r); err != nil {
+			return nil, err
+		}
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+		r, size = &buf, int64(buf.Len())
+	}
+
+	req, err := http.NewRequest("POST", u.String(), r)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "")
+	req.Header.Set("User-Agent", c.userAgent)
+	c.setHeaders(req)
+	if c.compressWrites {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	params := req.URL.Query()
+	params.Set("db", database)
+	params.Set("rp", retentionPolicy)
+	params.Set("precision", precision)
+	params.Set("consistency", writeConsistency)
+	req.URL.RawQuery = params.Encode()
+
+	req, cancel := withTimeout(req, c.writeTimeout)
+	defer cancel()
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response Response
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil && err.Error() != "EOF" {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		err := errors.New(string(body))
+		response.Err = err
+		response.StatusCode = resp.StatusCode
+		return &response, err
+	}
+
+	// An accepted write may still report an error, such as dropped points
+	var result struct {
+		Err string `json:"error"`
+	}
+	if len(bytes.TrimSpace(body)) > 0 && json.Unmarshal(body, &result) == nil && result.Err != "" {
+		response.Err = errors.New(result.Err)
+		response.StatusCode = resp.StatusCode
+		return &response, nil
+	}
+
+	return nil, nil
+}
+
 // Ping will check to see if the server is up
 // Ping returns how long the request took, the version of the server it connected to, and an error if one occurred.
 func (c *Client) Ping() (time.Duration, string, error) {
@@ -195,10 +370,10 @@ func (c *Client) Ping() (time.Duration, string, error) {
 		return 0, "", err
 	}
 	req.Header.Set("User-Agent", c.userAgent)
-	if c.username != "" {
-		req.SetBasicAuth(c.username, c.password)
-	}
+	c.setHeaders(req)
 
+	req, cancel := withTimeout(req, c.timeout)
+	defer cancel()
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return 0, "", err
@@ -223,18 +398,34 @@ func (c *Client) Dump(db string) (io.ReadCloser, error) {
 		return nil, err
 	}
 	req.Header.Set("User-Agent", c.userAgent)
-	if c.username != "" {
-		req.SetBasicAuth(c.username, c.password)
-	}
+	c.setHeaders(req)
 
+	// The timeout covers reading the body, so it's only released once the
+	// body is closed
+	req, cancel := withTimeout(req, c.timeout)
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
+	body := &cancelBody{ReadCloser: resp.Body, cancel: cancel}
 	if resp.StatusCode != http.StatusOK {
-		return resp.Body, fmt.Errorf("HTTP Protocol error %d", resp.StatusCode)
+		return body, fmt.Errorf("HTTP Protocol error %d", resp.StatusCode)
 	}
-	return resp.Body, nil
+	return body, nil
+}
+
+// cancelBody is a response body that cancels its request's context once closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close closes the body and cancels the context.
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
 }
 
 // Structs
@@ -283,9 +474,11 @@ func (r *Result) UnmarshalJSON(b []byte) error {
 }
 
 // Response represents a list of statement results.
+// StatusCode is only populated for failed line protocol writes.
 type Response struct {
-	Results []Result
-	Err     error
+	Results    []Result
+	Err        error
+	StatusCode int
 }
 
 // MarshalJSON encodes the response into JSON.
@@ -581,3 +774,44 @@ func SetPrecision(t time.Time, precision string) time.Time {
 	}
 	return t
 }
+
+// linesReader reads a slice of lines as if they had been joined with newlines.
+type linesReader struct {
+	lines []string
+	i     int // index of the line being read
+	off   int // offset of the next byte to read within lines[i]
+}
+
+// size returns the total number of bytes that will be read.
+func (r *linesReader) size() int64 {
+	if len(r.lines) == 0 {
+		return 0
+	}
+	n := int64(len(r.lines) - 1)
+	for _, l := range r.lines {
+		n += int64(len(l))
+	}
+	return n
+}
+
+func (r *linesReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) && r.i < len(r.lines) {
+		l := r.lines[r.i]
+		if r.off < len(l) {
+			c := copy(p[n:], l[r.off:])
+			n += c
+			r.off += c
+			continue
+		}
+		if r.i < len(r.lines)-1 {
+			p[n] = '\n'
+			n++
+		}
+		r.i, r.off = r.i+1, 0
+	}
+	if n == 0 && r.i >= len(r.lines) {
+		return 0, io.EOF
+	}
+	return n, nil
+}
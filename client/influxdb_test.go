@@ -1,8 +1,12 @@
 package client_test
 
 import (
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -13,6 +17,46 @@ import (
 	"github.com/influxdb/influxdb/client"
 )
 
+// benchmarkLines is a batch of the default size used by the importer.
+var benchmarkLines = func() []string {
+	lines := make([]string, 5000)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("cpu,host=server%02d,region=us-east1 value=%d,idle=0.%d 14244733039069373%02d", i%100, i, i, i%100)
+	}
+	return lines
+}()
+
+func benchmarkClient(b *testing.B) *client.Client {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	b.Cleanup(ts.Close)
+	u, _ := url.Parse(ts.URL)
+	c, _ := client.NewClient(client.Config{URL: *u})
+	return c
+}
+
+func BenchmarkWriteLineProtocol_Join(b *testing.B) {
+	c := benchmarkClient(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.WriteLineProtocol(strings.Join(benchmarkLines, "\n"), "db0", "", "", ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteLines(b *testing.B) {
+	c := benchmarkClient(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.WriteLines(benchmarkLines, "db0", "", "", ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func BenchmarkUnmarshalJSON2Tags(b *testing.B) {
 	var bp client.BatchPoints
 	data := []byte(`
@@ -91,6 +135,102 @@ func TestNewClient(t *testing.T) {
 	}
 }
 
+func TestClient_UnsafeSsl(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Influxdb-Version", "x.x")
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	c, _ := client.NewClient(client.Config{URL: *u})
+	if _, _, err := c.Ping(); err == nil {
+		t.Fatal("expected an error verifying the self-signed certificate")
+	}
+
+	c, _ = client.NewClient(client.Config{URL: *u, UnsafeSsl: true})
+	if _, _, err := c.Ping(); err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+}
+
+func TestClient_AuthToken(t *testing.T) {
+	var auth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	c, err := client.NewClient(client.Config{URL: *u, AuthToken: "secret"})
+	if err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+	if _, _, err := c.Ping(); err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+	if auth != "Token secret" {
+		t.Fatalf("unexpected authorization header.  expected %q, actual %q", "Token secret", auth)
+	}
+
+	if _, err := client.NewClient(client.Config{URL: *u, AuthToken: "secret", Username: "user"}); err == nil {
+		t.Fatal("expected an error using an auth token with a username")
+	}
+}
+
+func TestClient_Headers(t *testing.T) {
+	var h http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h = r.Header
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	c, _ := client.NewClient(client.Config{
+		URL:       *u,
+		AuthToken: "secret",
+		Headers:   map[string]string{"X-Tenant-ID": "t0", "Authorization": "Token other"},
+	})
+	if _, err := c.WriteLineProtocol("cpu value=1", "db0", "", "", ""); err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+	if v := h.Get("X-Tenant-ID"); v != "t0" {
+		t.Fatalf("unexpected X-Tenant-ID header.  expected %q, actual %q", "t0", v)
+	}
+	if v := h.Get("Authorization"); v != "Token secret" {
+		t.Fatalf("unexpected authorization header.  expected %q, actual %q", "Token secret", v)
+	}
+}
+
+func TestClient_WriteLineProtocol_CompressWrites(t *testing.T) {
+	var body, encoding string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("unexpected error.  expected %v, actual %v", nil, err)
+			return
+		}
+		b, _ := ioutil.ReadAll(gz)
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	c, _ := client.NewClient(client.Config{URL: *u, CompressWrites: true})
+	if _, err := c.WriteLineProtocol("cpu value=1", "db0", "", "", ""); err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+	if encoding != "gzip" {
+		t.Fatalf("unexpected content encoding.  expected %q, actual %q", "gzip", encoding)
+	}
+	if body != "cpu value=1" {
+		t.Fatalf("unexpected body.  expected %q, actual %q", "cpu value=1", body)
+	}
+}
+
 func TestClient_Ping(t *testing.T) {
 	ts := emptyTestServer()
 	defer ts.Close()
@@ -191,6 +331,130 @@ func TestClient_Write(t *testing.T) {
 	}
 }
 
+func TestClient_WriteLineProtocol(t *testing.T) {
+	var body, query string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		query = r.URL.RawQuery
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	config := client.Config{URL: *u}
+	c, err := client.NewClient(config)
+	if err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+
+	data := "cpu,host=server01 value=1\ncpu,host=server02 value=2"
+	r, err := c.WriteLineProtocol(data, "db0", "rp0", "s", client.ConsistencyAny)
+	if err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+	if r != nil {
+		t.Fatalf("unexpected response. expected %v, actual %v", nil, r)
+	}
+	if body != data {
+		t.Fatalf("unexpected body. expected %q, actual %q", data, body)
+	}
+	if exp := "consistency=any&db=db0&precision=s&rp=rp0"; query != exp {
+		t.Fatalf("unexpected query. expected %q, actual %q", exp, query)
+	}
+}
+
+func TestClient_WriteLines(t *testing.T) {
+	var body string
+	var length int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body, length = string(b), r.ContentLength
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	c, _ := client.NewClient(client.Config{URL: *u})
+
+	for _, lines := range [][]string{
+		{"cpu,host=server01 value=1", "cpu,host=server02 value=2"},
+		{"cpu value=1", "", strings.Repeat("x", 100000) + " value=3"},
+		{"cpu value=1"},
+	} {
+		if _, err := c.WriteLines(lines, "db0", "", "", ""); err != nil {
+			t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+		}
+		data := strings.Join(lines, "\n")
+		if body != data {
+			t.Fatalf("unexpected body. expected %q, actual %q", data, body)
+		}
+		if length != int64(len(data)) {
+			t.Fatalf("unexpected content length. expected %d, actual %d", len(data), length)
+		}
+	}
+}
+
+func TestClient_WriteLineProtocol_Error(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unable to parse 'bad'"))
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	c, _ := client.NewClient(client.Config{URL: *u})
+
+	r, err := c.WriteLineProtocol("bad", "db0", "", "", "")
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if r == nil || r.Err == nil {
+		t.Fatalf("expected response with error, got %v", r)
+	}
+	if r.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status code. expected %d, actual %d", http.StatusBadRequest, r.StatusCode)
+	}
+}
+
+func TestClient_WriteLineProtocol_AcceptedError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"error":"partial write: field type conflict dropped=1"}`))
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	c, _ := client.NewClient(client.Config{URL: *u})
+
+	r, err := c.WriteLineProtocol("cpu value=1\ncpu value=\"a\"", "db0", "", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+	if r == nil || r.Err == nil || r.Err.Error() != "partial write: field type conflict dropped=1" {
+		t.Fatalf("expected response with error, got %v", r)
+	}
+}
+
+func TestClient_Proxy(t *testing.T) {
+	var host string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.URL.Host
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer proxy.Close()
+
+	p, _ := url.Parse(proxy.URL)
+	u, _ := url.Parse("http://influxdb.example:8086")
+	c, _ := client.NewClient(client.Config{URL: *u, Proxy: p})
+	if _, _, err := c.Ping(); err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+	if host != "influxdb.example:8086" {
+		t.Fatalf("unexpected proxied host.  expected %q, actual %q", "influxdb.example:8086", host)
+	}
+}
+
 func TestClient_UserAgent(t *testing.T) {
 	receivedUserAgent := ""
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -497,6 +761,75 @@ func TestBatchPoints_Normal(t *testing.T) {
 	}
 }
 
+func TestClient_WriteTimeout_QueryTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		if r.URL.Path == "/query" {
+			w.Write([]byte(`{"results":[{}]}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	c, err := client.NewClient(client.Config{URL: *u, Timeout: 10 * time.Millisecond, QueryTimeout: time.Second, WriteTimeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+
+	if _, err := c.Query(client.Query{Command: "CREATE DATABASE db0"}); err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+	_, err = c.WriteLineProtocol("cpu value=1", "db0", "", "", "")
+	if e, ok := err.(net.Error); !ok || !e.Timeout() {
+		t.Fatalf("unexpected error.  expected timeout error, got %v", err)
+	}
+	if _, _, err := c.Ping(); err == nil {
+		t.Fatalf("unexpected success.  expected timeout error")
+	}
+}
+
+func TestClient_Dump_Timeout(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("# DDL\n"))
+		w.(http.Flusher).Flush()
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	u, _ := url.Parse(ts.URL)
+	c, err := client.NewClient(client.Config{URL: *u, Timeout: 50 * time.Millisecond, WriteTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+
+	body, err := c.Dump("db0")
+	if err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+	defer body.Close()
+
+	read := make(chan error)
+	go func() {
+		b, err := ioutil.ReadAll(body)
+		if string(b) != "# DDL\n" {
+			t.Errorf("unexpected body: %q", b)
+		}
+		read <- err
+	}()
+	select {
+	case err := <-read:
+		if err == nil {
+			t.Fatalf("unexpected success.  expected timeout error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("unexpected hang.  expected timeout error")
+	}
+}
+
 func TestClient_Timeout(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		time.Sleep(1 * time.Second)
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// DefaultUDPPayloadSize is the largest payload a UDPClient sends by default.
+// It's small enough to avoid fragmentation on most networks.
+const DefaultUDPPayloadSize = 512
+
+// UDPConfig is used to specify how to connect to a server's UDP listener.
+type UDPConfig struct {
+	// Addr is the host and port the server listens for UDP writes on.
+	Addr string
+
+	// PayloadSize is the largest number of bytes sent in one packet. Zero
+	// uses DefaultUDPPayloadSize.
+	PayloadSize int
+}
+
+// UDPClient writes points to a server's UDP listener. Writes aren't
+// acknowledged, so points lost on the way, or rejected by the server, aren't
+// reported. The listener chooses the database the points are written to.
+type UDPClient struct {
+	conn        net.Conn
+	payloadSize int
+}
+
+// NewUDPClient returns a UDPClient sending to the address in c.
+func NewUDPClient(c UDPConfig) (*UDPClient, error) {
+	if c.Addr == "" {
+		return nil, errors.New("udp address required")
+	}
+	conn, err := net.Dial("udp", c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	size := c.PayloadSize
+	if size <= 0 {
+		size = DefaultUDPPayloadSize
+	}
+	return &UDPClient{conn: conn, payloadSize: size}, nil
+}
+
+// WriteLineProtocol sends lines delimited by line returns, in as few packets
+// as fit within the payload size. A line longer than the payload size is
+// sent in a packet of its own. Timestamps must be in nanoseconds.
+func (c *UDPClient) WriteLineProtocol(data string) error {
+	var payload []string
+	size := 0
+	for _, line := range strings.Split(data, "\n") {
+		if line == "" {
+			continue
+		}
+		if len(payload) > 0 && size+1+len(line) > c.payloadSize {
+			if err := c.send(payload); err != nil {
+				return err
+			}
+			payload, size = payload[:0], 0
+		}
+		if len(payload) > 0 {
+			size++
+		}
+		payload = append(payload, line)
+		size += len(line)
+	}
+	if len(payload) > 0 {
+		return c.send(payload)
+	}
+	return nil
+}
+
+// send writes lines as a single packet.
+func (c *UDPClient) send(lines []string) error {
+	_, err := c.conn.Write([]byte(strings.Join(lines, "\n")))
+	return err
+}
+
+// Close closes the connection.
+func (c *UDPClient) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,54 @@
+package client_test
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/client"
+)
+
+func TestUDPClient_WriteLineProtocol(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	c, err := client.NewUDPClient(client.UDPConfig{Addr: conn.LocalAddr().String(), PayloadSize: 30})
+	if err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+	defer c.Close()
+
+	data := "cpu value=1 1\ncpu value=2 2\ncpu value=3 3\ncpu,host=serverA,region=uswest value=4 4\n"
+	if err := c.WriteLineProtocol(data); err != nil {
+		t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+	}
+
+	exp := []string{
+		"cpu value=1 1\ncpu value=2 2",
+		"cpu value=3 3",
+		"cpu,host=serverA,region=uswest value=4 4",
+	}
+	var got []string
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for len(got) < len(exp) {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("unexpected error.  expected %v, actual %v", nil, err)
+		}
+		got = append(got, string(buf[:n]))
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected payloads.  expected %q, actual %q", exp, got)
+	}
+}
+
+func TestNewUDPClient_NoAddr(t *testing.T) {
+	if _, err := client.NewUDPClient(client.UDPConfig{}); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -16,6 +17,7 @@ import (
 	"text/tabwriter"
 
 	"github.com/influxdb/influxdb/client"
+	"github.com/influxdb/influxdb/importer/v8"
 	"github.com/peterh/liner"
 )
 
@@ -28,6 +30,10 @@ const (
 	default_host   = "localhost"
 	default_port   = 8086
 	default_format = "column"
+
+	// exitInterrupted is the exit code of an import stopped by Ctrl-C, the
+	// code a shell gives a process killed by SIGINT.
+	exitInterrupted = 130
 )
 
 type CommandLine struct {
@@ -39,12 +45,18 @@ type CommandLine struct {
 	Password        string
 	Database        string
 	Ssl             bool
+	UnsafeSsl       bool
 	RetentionPolicy string
 	Version         string
 	Pretty          bool   // controls pretty print for json
 	Format          string // controls the output format.  Valid values are json, csv, or column
 	Execute         string
 	ShowVersion     bool
+	Import          bool
+	Path            string
+	Compressed      bool
+	SkipDDL         bool
+	SampleRate      int
 }
 
 func main() {
@@ -57,10 +69,16 @@ func main() {
 	fs.StringVar(&c.Password, "password", c.Password, `Password to connect to the server.  Leaving blank will prompt for password (--password="").`)
 	fs.StringVar(&c.Database, "database", c.Database, "Database to connect to the server.")
 	fs.BoolVar(&c.Ssl, "ssl", false, "Use https for connecting to cluster.")
+	fs.BoolVar(&c.UnsafeSsl, "unsafeSsl", false, "Set this when connecting to the cluster using https and not use SSL verification.")
 	fs.StringVar(&c.Format, "format", default_format, "Format specifies the format of the server responses:  json, csv, or column.")
 	fs.BoolVar(&c.Pretty, "pretty", false, "Turns on pretty print for the json format.")
 	fs.StringVar(&c.Execute, "execute", c.Execute, "Execute command and quit.")
 	fs.BoolVar(&c.ShowVersion, "version", false, "Displays the InfluxDB version.")
+	fs.BoolVar(&c.Import, "import", false, "Import a previous database.")
+	fs.StringVar(&c.Path, "path", "", "path to the file to import, or - for standard input")
+	fs.BoolVar(&c.Compressed, "compressed", false, "set to true if the import file is compressed")
+	fs.BoolVar(&c.SkipDDL, "skipDDL", false, "import the data without executing the DDL commands in the import file")
+	fs.IntVar(&c.SampleRate, "sampleRate", 0, "import only one in every sampleRate lines, keeping the first of each series")
 
 	// Define our own custom usage to print
 	fs.Usage = func() {
@@ -79,12 +97,27 @@ func main() {
        Username to connect to the server.
   -ssl
         Use https for requests.
+  -unsafeSsl
+        Set this when connecting to the cluster using https and not use SSL verification.
   -execute 'command'
        Execute command and quit.
   -format 'json|csv|column'
        Format specifies the format of the server responses:  json, csv, or column.
   -pretty
        Turns on pretty print for the json format.
+  -import
+       Import a previous database export from file
+  -path
+       Path to file to import, or - to read from standard input. A quoted glob pattern imports every match,
+       and an http:// or https:// URL is downloaded as it is imported
+  -compressed
+       Set to true if the import file is gzip compressed. Gzip, bzip2 and zstd files are detected without it
+  -skipDDL
+       Import the data without executing the DDL commands in the import file, for databases and retention
+       policies that already exist
+  -sampleRate 'n'
+       Import only one in every n lines, keeping the first line of each series so that every measurement
+       and tag set exists, for loading a small sample of a dump
 
 Examples:
 
@@ -93,6 +126,9 @@ Examples:
 
 	# Connect to a specific database on startup and set database context:
     $ influx -database 'metrics' -host 'localhost' -port '8086'
+
+    # Import a previous database export from file:
+    $ influx -import -path 'export.txt.gz' -compressed
 `)
 	}
 	fs.Parse(os.Args[1:])
@@ -126,6 +162,29 @@ Examples:
 
 	c.connect("")
 
+	if c.Import {
+		u := url.URL{Scheme: "http", Host: fmt.Sprintf("%s:%d", c.Host, c.Port)}
+		if c.Ssl {
+			u.Scheme = "https"
+		}
+		config := v8.NewV8Config(c.Username, c.Password, "", client.ConsistencyAny, c.Path, version, u, c.Compressed, 0)
+		config.UnsafeSsl = c.UnsafeSsl
+		config.SkipDDL = c.SkipDDL
+		config.SampleRate = c.SampleRate
+		config.StopOnInterrupt = true
+		i := v8.NewV8(config)
+		if err := i.Import(); err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			c.Line.Close()
+			if errors.Is(err, v8.ErrInterrupted) {
+				os.Exit(exitInterrupted)
+			}
+			os.Exit(1)
+		}
+		c.Line.Close()
+		os.Exit(0)
+	}
+
 	if c.Execute != "" {
 		if err := c.ExecuteQuery(c.Execute); err != nil {
 			c.Line.Close()
@@ -257,6 +316,7 @@ func (c *CommandLine) connect(cmd string) {
 			Username:  c.Username,
 			Password:  c.Password,
 			UserAgent: "InfluxDBShell/" + version,
+			UnsafeSsl: c.UnsafeSsl,
 		})
 	if err != nil {
 		fmt.Printf("Could not create client %s", err)
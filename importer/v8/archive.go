@@ -0,0 +1,74 @@
+package v8
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// tarMagic is found at tarMagicOffset in the header of a POSIX or GNU tar
+// archive, which are followed by "\x0000" and " \x00" respectively.
+var tarMagic = []byte("ustar")
+
+const tarMagicOffset = 257
+
+// isTar returns true if the buffered content is a tar archive.
+func isTar(r *bufio.Reader) bool {
+	b, err := r.Peek(tarMagicOffset + len(tarMagic))
+	return err == nil && bytes.Equal(b[tarMagicOffset:], tarMagic)
+}
+
+// isDataFile returns true if the archive entry h is a file to import. Hidden
+// files, such as the ._ files macOS adds to archives, aren't.
+func isDataFile(h *tar.Header) bool {
+	return h.Typeflag == tar.TypeReg && !strings.HasPrefix(path.Base(h.Name), ".")
+}
+
+// importArchive imports each data file in the tar archive file, read from r,
+// one after another through s, so that a file inherits the context of the
+// one before it as separate files do. Each may be compressed. The files are
+// named after the archive, such as "backup.tar.gz/db0.txt", in locations.
+// The archive keeps turn until it has been read, as the DDL of any of its
+// files must be executed before that of the files after it.
+func (v8 *V8) importArchive(ctx context.Context, s *stream, file string, r io.Reader, turn *ddlTurn) error {
+	// A checkpoint can only resume from a file on disk
+	if v8.config.CheckpointFile != "" {
+		return fmt.Errorf("%s is an archive, which can't be imported with CheckpointFile", displayName(file))
+	}
+	if err := turn.wait(ctx); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading %s: %s", displayName(file), err)
+		}
+		if !isDataFile(h) {
+			continue
+		}
+
+		name := displayName(file) + "/" + h.Name
+		er, format, err := decompress(tr, CompressionAuto)
+		if err != nil {
+			return fmt.Errorf("reading %s: %s", name, err)
+		}
+		if format != CompressionNone {
+			atomic.StoreInt32(&v8.compressedInput, 1)
+		}
+		err = v8.importReader(ctx, s, name, er, nil)
+		er.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
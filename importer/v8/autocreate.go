@@ -0,0 +1,126 @@
+package v8
+
+import (
+	"fmt"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// schema is a database, or a retention policy of one when retentionPolicy is set.
+type schema struct {
+	database, retentionPolicy string
+}
+
+// noteCreated records the database or retention policy created by a DDL
+// command, so that it isn't created again by AutoCreateDatabase or
+// AutoCreateRetentionPolicy.
+func (v8 *V8) noteCreated(command string) {
+	if !v8.config.AutoCreateDatabase && !v8.config.AutoCreateRetentionPolicy {
+		return
+	}
+	q, err := influxql.ParseQuery(command)
+	if err != nil {
+		return
+	}
+	v8.createdMu.Lock()
+	defer v8.createdMu.Unlock()
+	for _, stmt := range q.Statements {
+		switch stmt := stmt.(type) {
+		case *influxql.CreateDatabaseStatement:
+			v8.markCreated(schema{database: stmt.Name})
+		case *influxql.CreateRetentionPolicyStatement:
+			v8.markCreated(schema{database: stmt.Database, retentionPolicy: stmt.Name})
+		}
+	}
+}
+
+// markCreated records that s has been created. The caller must hold createdMu.
+func (v8 *V8) markCreated(s schema) {
+	if v8.created[s] == nil {
+		done := v8.markCreating(s)
+		close(done)
+	}
+}
+
+// markCreating records that s is being created, returning the channel to
+// close once it has been. The caller must hold createdMu.
+func (v8 *V8) markCreating(s schema) chan struct{} {
+	if v8.created == nil {
+		v8.created = make(map[schema]chan struct{})
+	}
+	done := make(chan struct{})
+	v8.created[s] = done
+	return done
+}
+
+// autoCreate creates the database and retention policy that line l is about
+// to be written to, if they are to be created and haven't been already. The
+// DDL commands sent before are executed first, as they may create them.
+// Files read in parallel wait for each other's commands, so that no line is
+// written before its database has been created, but only a file writing to
+// the same database or retention policy waits for it to be created.
+func (v8 *V8) autoCreate(s *stream, l sourceLine) error {
+	db, rp := v8.targetDatabase(s.lineContext), v8.targetRetentionPolicy(s.lineContext)
+	if db == "" {
+		return nil
+	}
+	if !v8.config.AutoCreateDatabase && !v8.config.AutoCreateRetentionPolicy {
+		return nil
+	}
+
+	var targets []schema
+	if v8.config.AutoCreateDatabase {
+		targets = append(targets, schema{database: db})
+	}
+	if v8.config.AutoCreateRetentionPolicy && rp != "" {
+		targets = append(targets, schema{database: db, retentionPolicy: rp})
+	}
+
+	// Claim what no one has created or started creating, then create it
+	// without holding the lock, closing each channel claimed once done.
+	dones := make([]chan struct{}, len(targets))
+	claimed := make([]bool, len(targets))
+	v8.createdMu.Lock()
+	for i, t := range targets {
+		if dones[i] = v8.created[t]; dones[i] == nil {
+			dones[i], claimed[i] = v8.markCreating(t), true
+		}
+	}
+	v8.createdMu.Unlock()
+	defer func() {
+		for i := range targets {
+			if claimed[i] {
+				close(dones[i])
+			}
+		}
+	}()
+
+	synced := false
+	for i, t := range targets {
+		if !claimed[i] {
+			<-dones[i]
+			continue
+		}
+		if !synced {
+			v8.syncCommands()
+			synced = true
+		}
+
+		command := "CREATE DATABASE " + influxql.QuoteIdent(db)
+		if t.retentionPolicy != "" {
+			command = fmt.Sprintf("CREATE RETENTION POLICY %s ON %s DURATION INF REPLICATION 1",
+				influxql.QuoteIdent(rp), influxql.QuoteIdent(db))
+		}
+		err := v8.execute(command, db)
+		if err != nil && alreadyExists(err) {
+			err = nil
+		}
+		v8.commandDone(sourceLine{text: command, file: l.file, num: l.num}, err)
+		close(dones[i])
+		claimed[i] = false
+		if err != nil && v8.config.StrictDDL {
+			return &DDLError{Command: command, Location: l.String(), Err: err}
+		}
+	}
+	return nil
+}
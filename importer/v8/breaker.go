@@ -0,0 +1,163 @@
+package v8
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultBreakerThreshold is the number of consecutive batches that must
+	// fail before the server is checked, when no threshold is configured.
+	defaultBreakerThreshold = 3
+
+	// defaultBreakerMaxWait is how long to wait for the server to come back
+	// before aborting, when no maximum is configured.
+	defaultBreakerMaxWait = 5 * time.Minute
+
+	// maxBreakerBackoff is the longest delay between pings while waiting.
+	maxBreakerBackoff = 30 * time.Second
+)
+
+// breaker pauses writes while the server is unreachable. Once enough batches
+// in a row have failed with network or server errors, the server is pinged.
+// If it doesn't answer, every writer waits until it does, so that the rest of
+// the file isn't read and failed in the meantime.
+type breaker struct {
+	v8        *V8
+	threshold int
+	maxWait   time.Duration
+
+	mu       sync.Mutex
+	failures int    // consecutive failed batches
+	check    *check // set while the server is being checked
+}
+
+// check is a check of whether the server is reachable, which writers wait on.
+type check struct {
+	done    chan struct{}
+	resumed bool // whether writes were paused and have resumed, set before done is closed
+}
+
+// newBreaker returns a breaker for v8, or nil if it is disabled. It is
+// always disabled under SkipPing, as it relies on pings to check the server.
+func newBreaker(v8 *V8) *breaker {
+	b := &breaker{v8: v8, threshold: v8.config.BreakerThreshold, maxWait: v8.config.BreakerMaxWait}
+	if b.threshold < 0 || v8.config.SkipPing || v8.config.Output != nil {
+		return nil
+	} else if b.threshold == 0 {
+		b.threshold = defaultBreakerThreshold
+	}
+	if b.maxWait <= 0 {
+		b.maxWait = defaultBreakerMaxWait
+	}
+	return b
+}
+
+// wait blocks while writes are paused.
+func (b *breaker) wait(ctx context.Context) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	c := b.check
+	b.mu.Unlock()
+	if c == nil {
+		return
+	}
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+	}
+}
+
+// success records a batch that was written.
+func (b *breaker) success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.failures = 0
+	b.mu.Unlock()
+}
+
+// failure records a batch that failed with a network or server error. It
+// returns true if the server had become unreachable and has come back, in
+// which case the batch should be written again.
+func (b *breaker) failure(ctx context.Context) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	if c := b.check; c != nil {
+		// Another writer is already checking the server
+		b.mu.Unlock()
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return false
+		}
+		return c.resumed
+	}
+	b.failures++
+	if b.failures < b.threshold {
+		b.mu.Unlock()
+		return false
+	}
+	b.failures = 0
+	c := &check{done: make(chan struct{})}
+	b.check = c
+	b.mu.Unlock()
+
+	paused, err := b.waitForServer(ctx)
+
+	b.mu.Lock()
+	c.resumed = paused && err == nil && ctx.Err() == nil
+	close(c.done)
+	b.check = nil
+	b.mu.Unlock()
+	if err != nil {
+		b.v8.abort(err)
+	}
+	return c.resumed
+}
+
+// waitForServer pings the server until it answers, with a doubling delay
+// between attempts, and returns whether it had to wait. If the first ping is
+// answered the failures weren't caused by the server being unreachable.
+func (b *breaker) waitForServer(ctx context.Context) (paused bool, err error) {
+	if _, _, err := b.v8.client.Ping(); err == nil {
+		return false, nil
+	}
+	b.v8.logger().Printf("Server unreachable after %d failed batches, pausing writes for up to %s\n", b.threshold, b.maxWait)
+
+	backoff := b.v8.config.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	deadline := time.Now().Add(b.maxWait)
+	for {
+		wait := backoff
+		if remaining := time.Until(deadline); remaining <= 0 {
+			e := b.v8.writeError(atomic.LoadInt64(&b.v8.failedInserts))
+			e.msg = fmt.Sprintf("aborted after the server was unreachable for %s", b.maxWait)
+			return true, e
+		} else if wait > remaining {
+			wait = remaining
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return true, nil
+		}
+		if _, _, err := b.v8.client.Ping(); err == nil {
+			b.v8.logger().Printf("Server reachable again, resuming writes\n")
+			return true, nil
+		}
+		if backoff *= 2; backoff > maxBreakerBackoff {
+			backoff = maxBreakerBackoff
+		}
+	}
+}
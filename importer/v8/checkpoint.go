@@ -0,0 +1,207 @@
+package v8
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultCheckpointInterval is the number of batches between checkpoints
+// when no interval is configured.
+const defaultCheckpointInterval = 10
+
+// checkpoint records how far an import got, so that it can be resumed.
+// Every line up to and including Line of File has been written, or has
+// failed and been counted.
+type checkpoint struct {
+	File    string    `json:"file"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Line    int       `json:"line"`
+
+	DDLProcessed   bool `json:"ddlProcessed"`
+	TotalCommands  int  `json:"totalCommands"`
+	FailedCommands int  `json:"failedCommands"`
+	TotalInserts   int  `json:"totalInserts"`
+	FailedInserts  int  `json:"failedInserts"`
+	FailedBatches  int  `json:"failedBatches"`
+}
+
+// readCheckpoint reads the checkpoint at path. It returns nil if there is none.
+func readCheckpoint(path string) (*checkpoint, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// matches returns true if file is the same, unchanged file the checkpoint
+// was taken from. Only local files can be matched.
+func (cp *checkpoint) matches(file string) bool {
+	if file != cp.File || file == "-" || isURL(file) {
+		return false
+	}
+	fi, err := os.Stat(file)
+	return err == nil && fi.Size() == cp.Size && fi.ModTime().Equal(cp.ModTime)
+}
+
+// write saves the checkpoint to path, replacing any previous one.
+func (cp *checkpoint) write(path string) error {
+	if fi, err := os.Stat(cp.File); err == nil {
+		cp.Size, cp.ModTime = fi.Size(), fi.ModTime()
+	}
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a crash can't leave a partial checkpoint
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// batchResult is the outcome of writing a batch.
+type batchResult struct {
+	inserts, failedInserts, failedBatches int
+}
+
+// checkpointer tracks which batches have been written. As batches may be
+// written out of order, a checkpoint only ever covers the batches up to the
+// first one that is still in flight.
+type checkpointer struct {
+	mu       sync.Mutex
+	v8       *V8
+	path     string
+	interval int
+
+	next    int // sequence number of the oldest batch in flight
+	pending map[int]completedBatch
+	cp      checkpoint
+	last    int // value of next when the checkpoint was last written
+}
+
+// completedBatch is a batch that was written before an older one.
+type completedBatch struct {
+	file   string
+	line   int
+	result batchResult
+}
+
+// newCheckpointer returns a checkpointer writing to v8's CheckpointFile,
+// continuing from cp if it is not nil.
+func newCheckpointer(v8 *V8, cp *checkpoint) *checkpointer {
+	c := &checkpointer{
+		v8:       v8,
+		path:     v8.config.CheckpointFile,
+		interval: v8.config.CheckpointInterval,
+		pending:  make(map[int]completedBatch),
+	}
+	if c.interval <= 0 {
+		c.interval = defaultCheckpointInterval
+	}
+	if cp != nil {
+		c.cp = *cp
+	}
+	return c
+}
+
+// done records that the batch with sequence number seq has been written, and
+// writes a checkpoint every interval batches.
+func (c *checkpointer) done(seq int, b lineBatch, r batchResult) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pending[seq] = completedBatch{file: b.file, line: b.nums[len(b.nums)-1], result: r}
+	for {
+		p, ok := c.pending[c.next]
+		if !ok {
+			break
+		}
+		delete(c.pending, c.next)
+		c.next++
+		c.cp.File, c.cp.Line = p.file, p.line
+		c.cp.TotalInserts += p.result.inserts
+		c.cp.FailedInserts += p.result.failedInserts
+		c.cp.FailedBatches += p.result.failedBatches
+	}
+	if c.next-c.last >= c.interval {
+		c.save()
+	}
+}
+
+// save writes the checkpoint, if any batches have been written since the last one.
+func (c *checkpointer) save() {
+	if c.next == c.last {
+		return
+	}
+	c.last = c.next
+	c.cp.DDLProcessed = true
+	c.cp.TotalCommands = int(atomic.LoadInt64(&c.v8.totalCommands))
+	c.cp.FailedCommands = int(atomic.LoadInt64(&c.v8.failedCommands))
+	if err := c.cp.write(c.path); err != nil {
+		c.v8.logger().Printf("error writing checkpoint: %s\n", err)
+	}
+}
+
+// finish writes a final checkpoint if the import stopped early, or removes
+// the checkpoint if it completed.
+func (c *checkpointer) finish(completed bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !completed {
+		c.save()
+		return
+	}
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		c.v8.logger().Printf("error removing checkpoint: %s\n", err)
+	}
+}
+
+// resume sets up checkpointing, continuing from the saved checkpoint if it
+// matches one of files. It returns the files that are left to import.
+func (v8 *V8) resume(files []string) ([]string, error) {
+	cp, err := readCheckpoint(v8.config.CheckpointFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read checkpoint: %s", err)
+	}
+	if cp != nil {
+		for i, file := range files {
+			if !cp.matches(file) {
+				continue
+			}
+			v8.logger().Printf("Resuming from %s:%d\n", displayName(cp.File), cp.Line)
+			v8.ddlProcessed = cp.DDLProcessed
+			v8.resumeFile, v8.resumeLine = cp.File, cp.Line
+			v8.totalCommands, v8.failedCommands = int64(cp.TotalCommands), int64(cp.FailedCommands)
+			v8.totalInserts, v8.failedInserts = int64(cp.TotalInserts), int64(cp.FailedInserts)
+			v8.failedBatches = int64(cp.FailedBatches)
+			v8.checkpointer = newCheckpointer(v8, cp)
+			return files[i:], nil
+		}
+		v8.logger().Printf("Ignoring checkpoint for %s, which doesn't match the input\n", displayName(cp.File))
+	}
+	v8.checkpointer = newCheckpointer(v8, nil)
+	return files, nil
+}
@@ -0,0 +1,97 @@
+package v8
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression formats understood by the importer.
+const (
+	// CompressionAuto detects gzip, bzip2 and zstd content from its header.
+	CompressionAuto = ""
+
+	CompressionNone  = "none"
+	CompressionGzip  = "gzip"
+	CompressionBzip2 = "bzip2"
+	CompressionZstd  = "zstd"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+	// utf8BOM is the byte order mark some Windows editors start files with.
+	utf8BOM = []byte{0xef, 0xbb, 0xbf}
+)
+
+// decompress wraps r in a reader for the given compression format, and
+// returns the format. When the format is CompressionAuto it is detected from
+// the first bytes of r. The returned reader must be closed once it has been read.
+func decompress(r io.Reader, format string) (io.ReadCloser, string, error) {
+	br := bufio.NewReader(r)
+	if format == CompressionAuto {
+		format = detectCompression(br)
+	}
+
+	switch format {
+	case CompressionNone:
+		return ioutil.NopCloser(br), format, nil
+	case CompressionGzip:
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, format, err
+		}
+		// Dumps made by concatenating gzip files are read to the end of the
+		// last member, rather than stopping after the first
+		gz.Multistream(true)
+		return gz, format, nil
+	case CompressionBzip2:
+		// The bzip2 reader has nothing to release.
+		return ioutil.NopCloser(bzip2.NewReader(br)), format, nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, format, err
+		}
+		return zr.IOReadCloser(), format, nil
+	default:
+		return nil, format, fmt.Errorf("unknown compression %q", format)
+	}
+}
+
+// detectCompression returns the compression format of the buffered content
+// based on its magic number.
+func detectCompression(r *bufio.Reader) string {
+	if hasPrefix(r, gzipMagic) {
+		return CompressionGzip
+	} else if hasPrefix(r, bzip2Magic) {
+		return CompressionBzip2
+	} else if hasPrefix(r, zstdMagic) {
+		return CompressionZstd
+	}
+	return CompressionNone
+}
+
+// hasPrefix returns true if the buffered content starts with prefix.
+func hasPrefix(r *bufio.Reader, prefix []byte) bool {
+	b, err := r.Peek(len(prefix))
+	return err == nil && bytes.Equal(b, prefix)
+}
+
+// skipBOM returns a reader for the content of r after any UTF-8 byte order
+// mark it starts with.
+func skipBOM(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	if hasPrefix(br, utf8BOM) {
+		br.Discard(len(utf8BOM))
+	}
+	return br
+}
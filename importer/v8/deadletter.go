@@ -0,0 +1,93 @@
+package v8
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// deadLetter records lines that could not be written in a format the
+// importer can read back, so that only the failed lines need to be replayed.
+type deadLetter struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+
+	// The context most recently written to the file. The precision of a file
+	// that is appended to is empty until a header is written, as the file
+	// may already end with one.
+	database, retentionPolicy, precision string
+
+	// Whether precision headers are written. They aren't when the precision
+	// was configured, as it is given again when the file is replayed.
+	precisionHeaders bool
+}
+
+// newDeadLetter opens path for appending and writes dmlMarker.
+func newDeadLetter(path, dmlMarker string, precisionHeaders bool) (*deadLetter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	d := &deadLetter{f: f, w: bufio.NewWriter(f), precisionHeaders: precisionHeaders}
+	if fi, err := f.Stat(); err == nil && fi.Size() == 0 {
+		d.precision = "n"
+	}
+	if _, err := d.w.WriteString(dmlMarker + "\n"); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// write appends the lines of b to the file, preceded by context headers
+// whenever the database, retention policy or precision differs from the previous write,
+// and by a comment giving where the lines were read from.
+func (d *deadLetter) write(b lineBatch) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if b.database != d.database {
+		if _, err := fmt.Fprintf(d.w, "# CONTEXT-DATABASE:%s\n", b.database); err != nil {
+			return err
+		}
+		d.database = b.database
+	}
+	if b.retentionPolicy != d.retentionPolicy {
+		if _, err := fmt.Fprintf(d.w, "# CONTEXT-RETENTION-POLICY:%s\n", b.retentionPolicy); err != nil {
+			return err
+		}
+		d.retentionPolicy = b.retentionPolicy
+	}
+	if p := parsePrecision(b.precision); d.precisionHeaders && p != d.precision {
+		if _, err := fmt.Fprintf(d.w, "%s%s\n", contextPrecision, p); err != nil {
+			return err
+		}
+		d.precision = p
+	}
+	if _, err := fmt.Fprintf(d.w, "# %s\n", b.location()); err != nil {
+		return err
+	}
+	for _, l := range b.lines {
+		if _, err := d.w.WriteString(l); err != nil {
+			return err
+		}
+		if err := d.w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes any buffered lines and closes the file.
+func (d *deadLetter) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.w.Flush(); err != nil {
+		d.f.Close()
+		return err
+	}
+	return d.f.Close()
+}
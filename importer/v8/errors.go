@@ -0,0 +1,65 @@
+package v8
+
+// ConnectError is returned when an import can't create a client for, or
+// reach, the server, a replica, or the UDP address. Nothing has been
+// imported when it is returned.
+type ConnectError struct {
+	// Addr is the host of the server or replica, or the UDP address.
+	Addr string
+
+	// Replica is true if Addr is one of the ReplicaURLs.
+	Replica bool
+
+	// Err is the underlying error.
+	Err error
+
+	msg string
+}
+
+func (e *ConnectError) Error() string { return e.msg }
+
+// Unwrap returns the underlying error.
+func (e *ConnectError) Unwrap() error { return e.Err }
+
+// DDLError is returned when a DDL command fails under StrictDDL.
+type DDLError struct {
+	// Command is the command that failed.
+	Command string
+
+	// Location is the file and line of the command, or of the line that a
+	// database or retention policy was being created for.
+	Location string
+
+	// Err is the error the server returned.
+	Err error
+}
+
+func (e *DDLError) Error() string {
+	return "DDL command failed: " + e.Location + ": " + e.Command + ": " + e.Err.Error()
+}
+
+// Unwrap returns the error the server returned.
+func (e *DDLError) Unwrap() error { return e.Err }
+
+// WriteError is returned when an import is aborted because writes failed:
+// after a batch failed under StopOnWriteError, after more than
+// MaxFailedInserts failed, or after the server was unreachable for longer
+// than BreakerMaxWait.
+type WriteError struct {
+	// Batch is the location of the batch that failed under StopOnWriteError,
+	// and Err the error it failed with. Both are empty otherwise.
+	Batch string
+	Err   error
+
+	// FailedInserts, Inserts and BytesRead are the number of inserts that
+	// had failed, the number written, and the bytes read, when the import
+	// was aborted.
+	FailedInserts, Inserts, BytesRead int64
+
+	msg string
+}
+
+func (e *WriteError) Error() string { return e.msg }
+
+// Unwrap returns the error the batch failed with, if any.
+func (e *WriteError) Unwrap() error { return e.Err }
@@ -0,0 +1,134 @@
+package v8
+
+import (
+	"fmt"
+	"strings"
+)
+
+// fixEscaping returns line with the spaces, commas and equals signs that
+// should have been escaped in its series key and field keys escaped. A
+// line's field set is the last part of it, before any timestamp, so the
+// unescaped spaces before it are taken to be part of the measurement or of a
+// tag value. Commas in the series key that don't start a key=value tag, and
+// commas in the field set that don't start a key=value field, are taken to
+// be part of the name or value before them. Lines that are escaped correctly
+// are returned unchanged.
+func fixEscaping(line string) string {
+	parts := splitUnquoted(line, ' ')
+	for _, p := range parts {
+		// Repeated spaces are left for the server to reject
+		if p == "" {
+			return line
+		}
+	}
+	var ts string
+	if n := len(parts); n > 2 && isInteger(parts[n-1]) {
+		ts, parts = parts[n-1], parts[:n-1]
+	}
+	// Without a field set to go by, the line is left for the server to reject
+	if len(parts) < 2 || keyEnd(parts[len(parts)-1]) == len(parts[len(parts)-1]) {
+		return line
+	}
+
+	fixed := fixSeriesKey(strings.Join(parts[:len(parts)-1], `\ `)) + " " + fixFieldKeys(parts[len(parts)-1])
+	if ts != "" {
+		fixed += " " + ts
+	}
+	return fixed
+}
+
+// escapingError returns an error describing how line is escaped wrongly, or
+// nil if it is escaped correctly.
+func escapingError(line string) error {
+	if fixed := fixEscaping(line); fixed != line {
+		return fmt.Errorf("unescaped space, comma or equals sign in the series key or a field key, expected %s", fixed)
+	}
+	return nil
+}
+
+// fixSeriesKey escapes the commas of key that don't start a tag, and the
+// equals signs in tag values.
+func fixSeriesKey(key string) string {
+	parts := splitUnquoted(key, ',')
+	fixed := []string{parts[0]}
+	for _, p := range parts[1:] {
+		eq := keyEnd(p)
+		if eq == len(p) {
+			fixed[len(fixed)-1] += `\,` + p
+			continue
+		}
+		fixed = append(fixed, p[:eq+1]+escapeUnescaped(p[eq+1:], '='))
+	}
+	return strings.Join(fixed, ",")
+}
+
+// fixFieldKeys escapes the commas of a field set that don't start a field.
+func fixFieldKeys(fields string) string {
+	parts := splitUnquoted(fields, ',')
+	var fixed []string
+	for i, p := range parts {
+		if keyEnd(p) == len(p) && i < len(parts)-1 {
+			parts[i+1] = p + `\,` + parts[i+1]
+			continue
+		}
+		fixed = append(fixed, p)
+	}
+	return strings.Join(fixed, ",")
+}
+
+// splitUnquoted splits s at each sep that isn't escaped or inside a quoted
+// string.
+func splitUnquoted(s string, sep byte) []string {
+	var parts []string
+	quoted, last := false, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, s[last:i])
+				last = i + 1
+			}
+		}
+	}
+	return append(parts, s[last:])
+}
+
+// escapeUnescaped escapes each c in s that isn't already escaped.
+func escapeUnescaped(s string, c byte) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			b.WriteByte(s[i])
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+			continue
+		case c:
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// isInteger returns true if s is a decimal integer, such as a timestamp.
+func isInteger(s string) bool {
+	if strings.HasPrefix(s, "-") {
+		s = s[1:]
+	}
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,351 @@
+package v8
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/influxdb/influxdb/client"
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/meta"
+	"github.com/influxdb/influxdb/tsdb"
+)
+
+// defaultChunkSize is the number of points queried per series at a time when
+// no chunk size is configured.
+const defaultChunkSize = 10000
+
+// ExportConfig is the config used to initialize an Exporter.
+type ExportConfig struct {
+	// Database is the database to export.
+	Database string
+
+	// IncludeMeasurements, if set, limits the export to measurements matching
+	// one of these glob patterns, such as "cpu*". The retention policies are
+	// always exported.
+	IncludeMeasurements []string
+
+	// Compressed gzips the output.
+	Compressed bool
+
+	// ChunkSize is the number of points queried per series at a time.
+	// Zero uses chunks of 10,000 points.
+	ChunkSize int
+}
+
+// Exporter writes a database out in the format read by the importer: the
+// version of the server, if it reports one, DDL recreating the database and
+// its retention policies, then the points of each retention policy as line
+// protocol under CONTEXT headers.
+type Exporter struct {
+	client *client.Client
+	config ExportConfig
+}
+
+// NewExporter returns an Exporter reading from the server c is connected to.
+func NewExporter(c *client.Client, config ExportConfig) *Exporter {
+	return &Exporter{client: c, config: config}
+}
+
+// retentionPolicy is a retention policy as reported by SHOW RETENTION POLICIES.
+type retentionPolicy struct {
+	name      string
+	duration  time.Duration
+	replicaN  int
+	isDefault bool
+}
+
+// Export writes the database to w.
+func (e *Exporter) Export(w io.Writer) error {
+	return e.ExportContext(context.Background(), w)
+}
+
+// ExportContext writes the database to w, stopping between queries if ctx is
+// cancelled. What has been written by then is a valid, but partial, dump.
+func (e *Exporter) ExportContext(ctx context.Context, w io.Writer) (err error) {
+	if e.config.Database == "" {
+		return fmt.Errorf("no database to export")
+	}
+
+	if e.config.Compressed {
+		gz := gzip.NewWriter(w)
+		defer func() {
+			if cerr := gz.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		w = gz
+	}
+	bw := bufio.NewWriter(w)
+	defer func() {
+		if ferr := bw.Flush(); err == nil {
+			err = ferr
+		}
+	}()
+
+	db := e.config.Database
+	rps, err := e.retentionPolicies(db)
+	if err != nil {
+		return err
+	}
+	measurements, err := e.measurements(db)
+	if err != nil {
+		return err
+	}
+
+	if _, v, err := e.client.Ping(); err == nil && v != "" {
+		fmt.Fprintf(bw, "%s%s\n", exportVersion, v)
+	}
+	fmt.Fprintf(bw, "# DDL\n")
+	fmt.Fprintf(bw, "CREATE DATABASE %s\n", influxql.QuoteIdent(db))
+	for _, rp := range rps {
+		// The server creates the default retention policy along with the
+		// database, so creating it again would fail. It is altered instead,
+		// in case it was changed.
+		verb := "CREATE"
+		if rp.name == meta.AutoCreateRetentionPolicyName {
+			verb = "ALTER"
+		}
+		fmt.Fprintf(bw, "%s RETENTION POLICY %s ON %s DURATION %s REPLICATION %d", verb,
+			influxql.QuoteIdent(rp.name), influxql.QuoteIdent(db), formatDuration(rp.duration), rp.replicaN)
+		if rp.isDefault {
+			fmt.Fprintf(bw, " DEFAULT")
+		}
+		fmt.Fprintf(bw, "\n")
+	}
+
+	fmt.Fprintf(bw, "\n# DML\n")
+	fmt.Fprintf(bw, "# CONTEXT-DATABASE:%s\n", db)
+	for _, rp := range rps {
+		fmt.Fprintf(bw, "# CONTEXT-RETENTION-POLICY:%s\n", rp.name)
+		for _, m := range measurements {
+			err := e.eachPoint(ctx, db, rp.name, m, func(line string) error {
+				_, err := fmt.Fprintln(bw, line)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// eachPoint calls fn with the line protocol for each point of measurement m
+// in retention policy rp of database db, querying a chunk of each series at
+// a time.
+func (e *Exporter) eachPoint(ctx context.Context, db, rp, m string, fn func(line string) error) error {
+	// The fields of m that hold floats. Whole floats come back from a query
+	// looking like integers, so a field is a float if any of its values isn't
+	// an integer. That can be in any chunk, so every chunk is read to find
+	// them before any points are formatted, and each field is written with
+	// the same type throughout.
+	floats := make(map[string]bool)
+	err := e.eachChunk(ctx, db, rp, m, func(rows []influxql.Row) error {
+		for _, row := range rows {
+			floatFields(row, floats)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return e.eachChunk(ctx, db, rp, m, func(rows []influxql.Row) error {
+		for _, row := range rows {
+			for _, values := range row.Values {
+				line, err := formatPoint(row, values, floats)
+				if err != nil {
+					return fmt.Errorf("exporting %s: %s", m, err)
+				}
+				if line == "" {
+					continue
+				}
+				if err := fn(line); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// eachChunk calls fn with the rows of each chunk of the points of measurement
+// m in retention policy rp of database db, until a chunk has no points.
+func (e *Exporter) eachChunk(ctx context.Context, db, rp, m string, fn func(rows []influxql.Row) error) error {
+	chunk := e.config.ChunkSize
+	if chunk <= 0 {
+		chunk = defaultChunkSize
+	}
+
+	from := influxql.QuoteIdent(db, rp, m)
+	for offset := 0; ; offset += chunk {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		q := fmt.Sprintf("SELECT * FROM %s GROUP BY * LIMIT %d OFFSET %d", from, chunk, offset)
+		rows, err := e.query(db, q)
+		if err != nil {
+			return err
+		}
+
+		n := 0
+		for _, row := range rows {
+			n += len(row.Values)
+		}
+		if n == 0 {
+			return nil
+		}
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+}
+
+// retentionPolicies returns the retention policies of database db.
+func (e *Exporter) retentionPolicies(db string) ([]retentionPolicy, error) {
+	rows, err := e.query(db, "SHOW RETENTION POLICIES ON "+influxql.QuoteIdent(db))
+	if err != nil {
+		return nil, err
+	}
+
+	var rps []retentionPolicy
+	for _, row := range rows {
+		for _, values := range row.Values {
+			v := columns(row.Columns, values)
+			rp := retentionPolicy{}
+			rp.name, _ = v["name"].(string)
+			if s, ok := v["duration"].(string); ok {
+				if rp.duration, err = time.ParseDuration(s); err != nil {
+					return nil, fmt.Errorf("retention policy %s has invalid duration %q", rp.name, s)
+				}
+			}
+			if n, ok := v["replicaN"].(json.Number); ok {
+				r, _ := n.Int64()
+				rp.replicaN = int(r)
+			}
+			rp.isDefault, _ = v["default"].(bool)
+			if rp.replicaN < 1 {
+				rp.replicaN = 1
+			}
+			rps = append(rps, rp)
+		}
+	}
+	return rps, nil
+}
+
+// measurements returns the names of the measurements of database db that
+// pass the measurement filter.
+func (e *Exporter) measurements(db string) ([]string, error) {
+	rows, err := e.query(db, "SHOW MEASUREMENTS")
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, row := range rows {
+		for _, values := range row.Values {
+			name, _ := columns(row.Columns, values)["name"].(string)
+			if name == "" {
+				continue
+			}
+			if len(e.config.IncludeMeasurements) > 0 && !matchAny(e.config.IncludeMeasurements, name) {
+				continue
+			}
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// query runs command against database db and returns the rows of its result.
+func (e *Exporter) query(db, command string) ([]influxql.Row, error) {
+	resp, err := e.client.Query(client.Query{Command: command, Database: db})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", command, err)
+	} else if resp.Error() != nil {
+		return nil, fmt.Errorf("%s: %s", command, resp.Error())
+	}
+
+	var rows []influxql.Row
+	for _, r := range resp.Results {
+		rows = append(rows, r.Series...)
+	}
+	return rows, nil
+}
+
+// columns maps the columns of a row to one of its values.
+func columns(names []string, values []interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(names))
+	for i, name := range names {
+		if i < len(values) {
+			m[name] = values[i]
+		}
+	}
+	return m
+}
+
+// floatFields adds the fields of row with a value that isn't an integer to floats.
+func floatFields(row influxql.Row, floats map[string]bool) {
+	for _, values := range row.Values {
+		for name, v := range columns(row.Columns, values) {
+			if n, ok := v.(json.Number); ok && name != "time" {
+				if _, err := strconv.ParseInt(string(n), 10, 64); err != nil {
+					floats[name] = true
+				}
+			}
+		}
+	}
+}
+
+// formatPoint returns the line protocol for one of the values of row, with
+// the fields in floats written as floats. It returns an empty string if the
+// point has no fields set.
+func formatPoint(row influxql.Row, values []interface{}, floats map[string]bool) (string, error) {
+	var t time.Time
+	fields := make(tsdb.Fields)
+	for name, v := range columns(row.Columns, values) {
+		if name == "time" {
+			s, _ := v.(string)
+			var err error
+			if t, err = time.Parse(time.RFC3339Nano, s); err != nil {
+				return "", fmt.Errorf("invalid time %q", s)
+			}
+			continue
+		}
+		switch v := v.(type) {
+		case nil:
+			// Fields missing from this point are returned as null
+		case json.Number:
+			if i, err := strconv.ParseInt(string(v), 10, 64); err == nil && !floats[name] {
+				fields[name] = i
+			} else {
+				f, err := v.Float64()
+				if err != nil {
+					return "", fmt.Errorf("invalid value %q for field %s", v, name)
+				}
+				fields[name] = f
+			}
+		default:
+			fields[name] = v
+		}
+	}
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return tsdb.NewPoint(row.Name, tsdb.Tags(row.Tags), fields, t).String(), nil
+}
+
+// formatDuration returns d as a retention policy duration, where zero means
+// the data is kept forever.
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return "INF"
+	}
+	return influxql.FormatDuration(d)
+}
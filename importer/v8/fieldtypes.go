@@ -0,0 +1,147 @@
+package v8
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// validFieldType returns true if t is a type that FieldTypeHints can coerce to.
+func validFieldType(t string) bool {
+	switch t {
+	case "integer", "float", "string", "boolean":
+		return true
+	}
+	return false
+}
+
+// coerceFields rewrites the fields of line that have a FieldTypeHints entry
+// so that the server parses them as the hinted type. Other fields, and the
+// rest of the line, are left as they are.
+func (v8 *V8) coerceFields(line string) (string, error) {
+	if len(v8.config.FieldTypeHints) == 0 {
+		return line, nil
+	}
+	start, end := fieldSet(line)
+	if start >= end {
+		return line, nil
+	}
+
+	name := measurementName(line)
+	fields := splitUnquoted(line[start:end], ',')
+	changed := false
+	for i, f := range fields {
+		eq := keyEnd(f)
+		if eq == len(f) {
+			continue
+		}
+		key := unescapeKey(f[:eq])
+		t, ok := v8.config.FieldTypeHints[name+"."+key]
+		if !ok {
+			continue
+		}
+		v, err := coerceValue(f[eq+1:], t)
+		if err != nil {
+			return "", fmt.Errorf("field %s: %s", key, err)
+		}
+		if v != f[eq+1:] {
+			fields[i], changed = f[:eq+1]+v, true
+		}
+	}
+	if !changed {
+		return line, nil
+	}
+	return line[:start] + strings.Join(fields, ",") + line[end:], nil
+}
+
+// coerceValue returns field value v written as type t.
+func coerceValue(v, t string) (string, error) {
+	quoted := len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"'
+	if t == "string" {
+		if quoted {
+			return v, nil
+		}
+		return `"` + strings.Replace(v, `"`, `\"`, -1) + `"`, nil
+	}
+	if quoted {
+		v = strings.Replace(v[1:len(v)-1], `\"`, `"`, -1)
+	}
+
+	switch t {
+	case "integer":
+		if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return v, nil
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return "", fmt.Errorf("%s is not an integer", v)
+		}
+		return strconv.FormatInt(int64(f), 10), nil
+	case "float":
+		// A number without a decimal point would be parsed as an integer
+		if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return v + ".0", nil
+		}
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return "", fmt.Errorf("%s is not a float", v)
+		}
+		return v, nil
+	case "boolean":
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return "", fmt.Errorf("%s is not a boolean", v)
+		}
+		return strconv.FormatBool(b), nil
+	}
+	return v, nil
+}
+
+// fieldSet returns the start and end of the field set of line, which follows
+// the first unescaped space and runs to the next one outside a quoted string.
+func fieldSet(line string) (start, end int) {
+	i := measurementEnd(line)
+	for ; i < len(line) && line[i] != ' '; i++ {
+		if line[i] == '\\' {
+			i++
+		}
+	}
+	start = i + 1
+	quoted := false
+	for i = start; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case ' ':
+			if !quoted {
+				return start, i
+			}
+		}
+	}
+	return start, len(line)
+}
+
+// keyEnd returns the index of the first unescaped = in field f, or the
+// length of f if there is none.
+func keyEnd(f string) int {
+	for i := 0; i < len(f); i++ {
+		switch f[i] {
+		case '\\':
+			i++
+		case '=':
+			return i
+		}
+	}
+	return len(f)
+}
+
+// unescapeKey removes the escaping from commas, spaces and equals signs in a
+// field key.
+func unescapeKey(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return strings.NewReplacer(`\,`, `,`, `\ `, ` `, `\=`, `=`).Replace(s)
+}
@@ -0,0 +1,166 @@
+package v8
+
+import (
+	"hash/fnv"
+	"path"
+	"strings"
+	"time"
+)
+
+// measurementName returns the unescaped measurement of a line protocol line,
+// which is everything before the first unescaped comma or space.
+func measurementName(line string) string {
+	return unescapeMeasurement(line[:measurementEnd(line)])
+}
+
+// measurementEnd returns the index of the first unescaped comma or space in
+// line, or the length of line if there is none.
+func measurementEnd(line string) int {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case ',', ' ':
+			return i
+		}
+	}
+	return len(line)
+}
+
+// unescapeMeasurement removes the escaping from commas and spaces in a
+// measurement name.
+func unescapeMeasurement(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return strings.NewReplacer(`\,`, `,`, `\ `, ` `).Replace(s)
+}
+
+// matchMeasurement returns true if name matches pattern. Patterns are globs,
+// and a trailing * also matches names containing a /.
+func matchMeasurement(pattern, name string) bool {
+	if strings.HasSuffix(pattern, "*") && strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) {
+		return true
+	}
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+// matchAny returns true if name matches any of patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if matchMeasurement(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// escapeMeasurement escapes the commas and spaces in a measurement name.
+func escapeMeasurement(s string) string {
+	if !strings.ContainsAny(s, ", ") {
+		return s
+	}
+	return strings.NewReplacer(`,`, `\,`, ` `, `\ `).Replace(s)
+}
+
+// rename replaces the measurement of line using the configured
+// MeasurementRename mapping. The tags, fields and timestamp are unchanged.
+func (v8 *V8) rename(line string) string {
+	if len(v8.config.MeasurementRename) == 0 {
+		return line
+	}
+	end := measurementEnd(line)
+	to, ok := v8.config.MeasurementRename[unescapeMeasurement(line[:end])]
+	if !ok {
+		return line
+	}
+	return escapeMeasurement(to) + line[end:]
+}
+
+// included returns true if the measurement of line passes the configured
+// include and exclude filters.
+func (v8 *V8) included(line string) bool {
+	if len(v8.config.IncludeMeasurements) == 0 && len(v8.config.ExcludeMeasurements) == 0 {
+		return true
+	}
+	name := measurementName(line)
+	if len(v8.config.IncludeMeasurements) > 0 && !matchAny(v8.config.IncludeMeasurements, name) {
+		return false
+	}
+	return !matchAny(v8.config.ExcludeMeasurements, name)
+}
+
+// sampled returns true if line, read through s, is kept by the configured
+// SampleRate: it is the first line of its series, or one in every
+// SampleRate of the lines counted.
+func (v8 *V8) sampled(s *stream, line string) bool {
+	if v8.config.SampleRate <= 1 {
+		return true
+	}
+	n := s.sampleCount
+	s.sampleCount++
+
+	// Only a hash of each series is kept, as every series in the import is
+	// remembered. Two series sharing a hash would just have the first line
+	// of the second sampled like any other.
+	h := fnv.New64a()
+	h.Write([]byte(seriesKey(line)))
+	key := h.Sum64()
+	if s.sampledSeries == nil {
+		s.sampledSeries = make(map[uint64]struct{})
+	}
+	if _, ok := s.sampledSeries[key]; !ok {
+		s.sampledSeries[key] = struct{}{}
+		return true
+	}
+	return n%v8.config.SampleRate == 0
+}
+
+// seriesKey returns the measurement and tags of a line protocol line, which
+// is everything before the first unescaped space.
+func seriesKey(line string) string {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case ' ':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// inWindow returns true if the timestamp of line, written with precision, is
+// within the configured StartTime and EndTime.
+func (v8 *V8) inWindow(precision, line string) bool {
+	if v8.config.StartTime.IsZero() && v8.config.EndTime.IsZero() {
+		return true
+	}
+	ts, ok := lineTimestamp(line)
+	if !ok {
+		return !v8.config.SkipUntimedLines
+	}
+	t := time.Unix(0, ts*int64(precisionUnit(precision)))
+	if !v8.config.StartTime.IsZero() && t.Before(v8.config.StartTime) {
+		return false
+	}
+	return v8.config.EndTime.IsZero() || t.Before(v8.config.EndTime)
+}
+
+// precisionUnit returns the duration of one unit of timestamps written with precision p.
+func precisionUnit(p string) time.Duration {
+	switch p {
+	case "u":
+		return time.Microsecond
+	case "ms":
+		return time.Millisecond
+	case "s":
+		return time.Second
+	case "m":
+		return time.Minute
+	case "h":
+		return time.Hour
+	}
+	return time.Nanosecond
+}
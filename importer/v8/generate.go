@@ -0,0 +1,164 @@
+package v8
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// generatedFile is the name that generated points are reported as coming
+// from, in failed line locations and the failed lines file.
+const generatedFile = "generated"
+
+// GenerateConfig describes the synthetic points that an import writes when
+// V8Config.Generate is set, for load testing a server without an export.
+type GenerateConfig struct {
+	// Database is the database the points are written to, and
+	// RetentionPolicy, if set, the retention policy.
+	Database, RetentionPolicy string
+
+	// Measurement is the measurement the points are written to.
+	Measurement string
+
+	// Tags maps each tag key to the number of values it takes, which are
+	// the key followed by a number from zero. A point is written to each
+	// combination of tag values in turn, so the number of series is the
+	// product of the counts.
+	Tags map[string]int
+
+	// Fields are the float fields of each point, which are given random
+	// values from 0 to 100. Defaults to a single field, "value".
+	Fields []string
+
+	// Points is the number of points written.
+	Points int
+
+	// Start is the time of the first point of each series, and Interval the
+	// time between the points of a series. Interval defaults to one second,
+	// and Start to the time that makes the last points current.
+	Start    time.Time
+	Interval time.Duration
+
+	// Seed seeds the field values, so that a run can be repeated exactly.
+	Seed int64
+}
+
+// validate checks that c describes points that can be written.
+func (c *GenerateConfig) validate() error {
+	if c.Database == "" {
+		return fmt.Errorf("a database is required to generate points")
+	} else if c.Measurement == "" {
+		return fmt.Errorf("a measurement is required to generate points")
+	} else if c.Points <= 0 {
+		return fmt.Errorf("the number of points to generate must be greater than zero")
+	}
+	for key, n := range c.Tags {
+		if n <= 0 {
+			return fmt.Errorf("tag %s must have at least one value", key)
+		}
+	}
+	return nil
+}
+
+// keyEscaper escapes the characters that need escaping in tag and field keys.
+var keyEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `, `=`, `\=`)
+
+// generator reads as an export of the points described by a GenerateConfig.
+// The points are generated as they are read, so any number can be written
+// without holding them in memory.
+type generator struct {
+	config GenerateConfig
+	keys   []string
+	fields []string
+	series int // number of series, up to the number of points
+	unit   time.Duration
+	rand   *rand.Rand
+
+	n   int // points generated so far
+	buf bytes.Buffer
+}
+
+// newGenerator returns a generator for the points of c, with timestamps
+// written with precision, following dmlMarker.
+func newGenerator(c GenerateConfig, dmlMarker, precision string) *generator {
+	g := &generator{
+		config: c,
+		fields: c.Fields,
+		series: 1,
+		unit:   precisionUnit(precision),
+		rand:   rand.New(rand.NewSource(c.Seed)),
+	}
+	for key := range c.Tags {
+		g.keys = append(g.keys, key)
+	}
+	// The server stores tags sorted by key, so write them that way
+	sort.Strings(g.keys)
+	for _, key := range g.keys {
+		if g.series *= c.Tags[key]; g.series >= c.Points {
+			g.series = c.Points
+			break
+		}
+	}
+	if len(g.fields) == 0 {
+		g.fields = []string{"value"}
+	}
+	if g.config.Interval <= 0 {
+		g.config.Interval = time.Second
+	}
+	if g.config.Start.IsZero() {
+		g.config.Start = time.Now().Add(-time.Duration((c.Points-1)/g.series) * g.config.Interval)
+	}
+
+	g.buf.WriteString(dmlMarker + "\n")
+	fmt.Fprintf(&g.buf, "# CONTEXT-DATABASE:%s\n", c.Database)
+	if c.RetentionPolicy != "" {
+		fmt.Fprintf(&g.buf, "# CONTEXT-RETENTION-POLICY:%s\n", c.RetentionPolicy)
+	}
+	return g
+}
+
+// Read generates points until p can be filled, or every point has been read.
+func (g *generator) Read(p []byte) (int, error) {
+	for g.buf.Len() < len(p) && g.n < g.config.Points {
+		g.writePoint()
+	}
+	if g.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return g.buf.Read(p)
+}
+
+// writePoint adds the next point to the buffer.
+func (g *generator) writePoint() {
+	g.buf.WriteString(escapeMeasurement(g.config.Measurement))
+
+	// Count through the combinations of tag values, the last key fastest
+	j := g.n % g.series
+	values := make([]int, len(g.keys))
+	for k := len(g.keys) - 1; k >= 0; k-- {
+		values[k] = j % g.config.Tags[g.keys[k]]
+		j /= g.config.Tags[g.keys[k]]
+	}
+	for k, key := range g.keys {
+		key = keyEscaper.Replace(key)
+		fmt.Fprintf(&g.buf, ",%s=%s%d", key, key, values[k])
+	}
+
+	for k, field := range g.fields {
+		if k == 0 {
+			g.buf.WriteByte(' ')
+		} else {
+			g.buf.WriteByte(',')
+		}
+		fmt.Fprintf(&g.buf, "%s=%s", keyEscaper.Replace(field), strconv.FormatFloat(g.rand.Float64()*100, 'f', 2, 64))
+	}
+
+	t := g.config.Start.Add(time.Duration(g.n/g.series) * g.config.Interval)
+	fmt.Fprintf(&g.buf, " %d\n", t.UnixNano()/int64(g.unit))
+	g.n++
+}
@@ -0,0 +1,1947 @@
+package v8
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/influxdb/influxdb/client"
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/tsdb"
+)
+
+const (
+	// defaultBatchSize is the number of lines written per request when no batch size is configured.
+	defaultBatchSize = 5000
+
+	// defaultRetryBackoff is the delay before the first retry when no backoff is configured.
+	defaultRetryBackoff = time.Second
+
+	// defaultMaxLineBytes is the longest line that can be read when no limit is configured.
+	defaultMaxLineBytes = 1 << 20
+)
+
+// V8Config is the config used to initialize a V8 importer
+type V8Config struct {
+	username, password string
+	url                url.URL
+	precision          string
+	writeConsistency   string
+	file, version      string
+	compressed         bool
+	batchSize          int
+
+	// MaxLineBytes is the longest line that can be read, in bytes, not
+	// counting the line ending. A longer line stops the import with an
+	// error. Zero uses a limit of 1MB.
+	MaxLineBytes int
+
+	// ReadAhead is the number of lines, and of DDL commands, that may be
+	// read from the file before earlier ones have been handled, so that
+	// reading isn't held up while a batch is handed off. Zero reads ahead
+	// by the batch size, and a negative value doesn't read ahead at all.
+	ReadAhead int
+
+	// Concurrency is the number of goroutines writing batches to the server.
+	// A value of zero or less uses a single writer.
+	Concurrency int
+
+	// DDLMarker and DMLMarker are the lines that start the DDL and DML
+	// sections of an export, for dumps written by tools that head them
+	// differently. A line starting with a marker starts its section. They
+	// default to "# DDL" and "# DML", and the failed lines file is written
+	// with DMLMarker so that it can be replayed with the same config.
+	DDLMarker, DMLMarker string
+
+	// MaxPendingBatches is the number of full batches that may wait for a
+	// writer. Once that many are waiting, reading stops until a writer takes
+	// one, so stalled writes hold up the reading rather than filling memory.
+	// At most Concurrency batches are being written, MaxPendingBatches are
+	// waiting and one is being filled for each file being read. Zero, the
+	// default, queues none: a batch is only handed off when a writer is free.
+	MaxPendingBatches int
+
+	// FileConcurrency is the number of files read at once when several are
+	// imported, such as a directory of exports. The files share the batch
+	// writers. Each starts with no context, rather than inheriting the one
+	// the file before it ended with, and DDL sections are still executed one
+	// at a time, in file order. A value of one or less reads the files one
+	// after another. It can't be combined with CheckpointFile.
+	FileConcurrency int
+
+	// MaxRetries is the number of times a batch is retried after a network
+	// or server (5xx) error. Client (4xx) errors are never retried.
+	MaxRetries int
+
+	// FallbackConsistency, if set, is the write consistency that retries
+	// use, such as "any" to let writes succeed while some of the nodes a
+	// "quorum" write needs are down. Points written that way may be lost if
+	// the one node holding them fails before they are copied to the others.
+	// It needs MaxRetries to be at least one.
+	FallbackConsistency string
+
+	// MeasurementOverrides maps measurements, named as in the export, to
+	// the write consistency and precision their lines are written with, so
+	// that critical measurements can be written with "quorum" and the rest
+	// with "any". A batch only holds lines written the same way, so each
+	// consistency and precision has a batch of its own. With a
+	// CheckpointFile, an export that interleaves such measurements is
+	// written in many small batches, and should be sorted by measurement first.
+	MeasurementOverrides map[string]WriteOverride
+
+	// RetryBackoff is the delay before the first retry. It doubles after
+	// each attempt. Defaults to one second.
+	RetryBackoff time.Duration
+
+	// RetryJitter randomises the delay before each retry, so that importers
+	// retrying against the same recovering server don't all retry at once.
+	// The default, JitterFull, waits for up to the backoff, JitterEqual for
+	// between half of it and all of it, and JitterNone for the backoff.
+	RetryJitter string
+
+	// BreakerThreshold is the number of batches in a row that must fail with
+	// network or server errors before the server is pinged. If it doesn't
+	// answer, writes are paused until it does, and the batch is written
+	// again. Zero uses a threshold of 3, and a negative value never pauses.
+	BreakerThreshold int
+
+	// BreakerMaxWait is how long writes are paused for before the import is
+	// aborted. Zero waits for up to five minutes.
+	BreakerMaxWait time.Duration
+
+	// FailedLinesFile, if set, is a file that lines are appended to when
+	// they can't be written. The file can be imported again to replay them.
+	FailedLinesFile string
+
+	// ResumeDownloads is the number of times the download of an http or
+	// https file is resumed if its connection drops, by requesting the rest
+	// of the file with a Range header. The server must support range
+	// requests, and the file mustn't change in between. Zero, the default,
+	// fails the import at the first dropped connection.
+	ResumeDownloads int
+
+	// Compression is the compression format of the file. It defaults to
+	// CompressionAuto, which detects the format from the file content.
+	Compression string
+
+	// DryRun parses the DDL and DML without sending anything to the server
+	// other than the initial ping. The summary reports what would have been
+	// written, with lines that fail to parse counted as failed inserts.
+	DryRun bool
+
+	// Progress, if set, is called with the running totals after each batch
+	// write and each DDL command. Calls never overlap.
+	Progress func(ProgressReport)
+
+	// ProgressInterval, if set, logs the inserts so far, the rate they are
+	// being written at, the number that failed and the estimated time left
+	// this often. The rate is measured over the last five intervals, so a
+	// slowdown shows quickly.
+	ProgressInterval time.Duration
+
+	// TargetDatabase, if set, replaces the database named by CONTEXT-DATABASE
+	// headers for every write and is used as the database for every DDL
+	// command. The text of DDL commands isn't rewritten, so a CREATE DATABASE
+	// for the original name still creates that database.
+	TargetDatabase string
+
+	// TargetRetentionPolicy, if set, replaces the retention policy named by
+	// CONTEXT-RETENTION-POLICY headers for every write.
+	TargetRetentionPolicy string
+
+	// DefaultRetentionPolicy, if set, is the retention policy written to
+	// when no CONTEXT-RETENTION-POLICY header has named one. Otherwise the
+	// retention policy is left empty, and the server writes to the
+	// database's default retention policy, whatever it is at the time.
+	DefaultRetentionPolicy string
+
+	// IncludeMeasurements, if set, limits the import to lines whose
+	// measurement matches one of these glob patterns, such as "cpu*".
+	IncludeMeasurements []string
+
+	// ExcludeMeasurements skips lines whose measurement matches one of these
+	// glob patterns. It is applied after IncludeMeasurements.
+	ExcludeMeasurements []string
+
+	// MeasurementRename maps measurement names to the names they are written
+	// as. Names are unescaped on both sides, and filters apply to the
+	// original names.
+	MeasurementRename map[string]string
+
+	// FieldTypeHints maps fields, named as "measurement.field", to the type
+	// they are written as: "integer", "float", "string" or "boolean". The
+	// server takes a number without a decimal point to be an integer, so a
+	// dump that wrote a whole float as "2" needs a "float" hint to keep the
+	// field a float. Names are those in the file, before MeasurementRename.
+	// A value that can't be written as its type rejects the line.
+	FieldTypeHints map[string]string
+
+	// StartTime and EndTime, if set, limit the import to points timestamped
+	// from StartTime up to, but not including, EndTime. Points outside the
+	// window are counted as skipped. Timestamps are read with the precision
+	// they are written with.
+	StartTime, EndTime time.Time
+
+	// SkipUntimedLines skips lines without a timestamp, which the server
+	// would give the time they are written, when StartTime or EndTime is
+	// set. They are kept by default.
+	SkipUntimedLines bool
+
+	// SampleRate, if greater than one, imports only one in every SampleRate
+	// lines that pass the filters, for loading a small but realistic sample
+	// into a development server. The first line of each series is always
+	// kept, so that every measurement and tag set still exists. The lines
+	// left out are counted as skipped. Lines are counted per file when
+	// FileConcurrency is set, so every run keeps the same lines. A hash of
+	// every series seen is kept until the import finishes, so memory grows
+	// with the number of distinct series, by a few tens of bytes each.
+	SampleRate int
+
+	// PointsPerSecond, if greater than zero, limits the rate at which points
+	// are written across all writers.
+	PointsPerSecond int
+
+	// JSONSummary prints the summary at the end of an import as a JSON
+	// object on standard output instead of logging it.
+	JSONSummary bool
+
+	// MeasurementSummary also logs the number of inserts written to each
+	// measurement at the end of an import.
+	MeasurementSummary bool
+
+	// Logger receives the importer's log messages. If nil, they are written
+	// through the standard log package.
+	Logger Logger
+
+	// BisectOnFailure splits a batch the server rejects into halves and
+	// writes each of them, repeating until the lines it rejects are isolated.
+	// The good lines are written and only the bad ones fail. This can take
+	// many writes for a batch with a lot of bad lines, so it is off by default.
+	BisectOnFailure bool
+
+	// MaxBatchBytes, if greater than zero, limits the size of the body of
+	// each write. A batch is written once it has batchSize lines or would
+	// grow past MaxBatchBytes, whichever comes first. A single line longer
+	// than the limit is still written, on its own.
+	MaxBatchBytes int
+
+	// Transform, if set, is called with each line to be inserted, after any
+	// MeasurementRename. The line it returns is inserted instead, or the line
+	// is skipped if it returns false. It is called from a single goroutine,
+	// or from one per file being read when FileConcurrency is set.
+	Transform func(line string) (string, bool)
+
+	// BeforeBatch and AfterBatch, if set, are called before and after each
+	// attempt at writing a batch to the server, with its number of lines,
+	// and for AfterBatch the error the attempt failed with and how long it
+	// took, for metrics and tracing. A retried batch is reported once for
+	// each attempt, and the halves of a split batch separately. They are
+	// called from each of the writers, so calls can overlap. Writes to
+	// replicas, and dry runs, aren't reported.
+	BeforeBatch func(size int)
+	AfterBatch  func(size int, err error, dur time.Duration)
+
+	// Dedup drops lines that are repeated within a batch before it is
+	// written. Repeats in different batches are still written.
+	Dedup bool
+
+	// SortBatch sorts the lines of each batch by timestamp before it is
+	// written. Lines without a timestamp are written last.
+	SortBatch bool
+
+	// CheckpointFile, if set, is where the progress of the import is saved
+	// every CheckpointInterval batches, and when it stops early. If the file
+	// exists when an import starts, and the file it refers to is unchanged,
+	// the import continues from there. The checkpoint is removed once the
+	// import completes. Only local files can be resumed.
+	CheckpointFile string
+
+	// CheckpointInterval is the number of batches between checkpoints. It
+	// defaults to 10.
+	CheckpointInterval int
+
+	// ValidateLines parses each line before it is batched. Invalid lines are
+	// counted as rejected and saved to FailedLinesFile, if set, instead of
+	// causing the whole batch to be refused by the server. Lines with spaces,
+	// commas or equals signs that FixEscaping would escape are rejected too,
+	// as the server may parse them into the wrong tags without an error.
+	// Lines are validated as written, after Transform, so lines skipped by
+	// the filters or Transform are never rejected.
+	ValidateLines bool
+
+	// FixEscaping escapes the spaces, commas and equals signs that a
+	// hand-edited dump left unescaped in the measurement, tag values and
+	// field keys of a line, instead of rejecting it under ValidateLines. The
+	// field set is taken to be the last part of the line before any
+	// timestamp, so spaces before it are escaped as part of the series key,
+	// and a comma not followed by a key=value pair as part of what precedes it.
+	FixEscaping bool
+
+	// MaxFailedInserts, if greater than zero, aborts the import once more
+	// than this many inserts have failed. Lines that were already read are
+	// still written, and the summary covers everything done until then.
+	MaxFailedInserts int
+
+	// StopOnWriteError aborts the import as soon as a batch fails to be
+	// written, once its retries are used up, as MaxFailedInserts would at the
+	// first failure. Failed DDL commands are handled by StrictDDL, so the two
+	// together stop on any failure, and either alone on failures of one kind.
+	StopOnWriteError bool
+
+	// SampleFailures is the number of failed lines kept, with the error
+	// their batch failed with, to be shown in the summary. Long lines are
+	// truncated.
+	SampleFailures int
+
+	// StrictDDL stops the import before any lines are written if a command in
+	// the DDL section fails. A command that fails in the DML section stops
+	// the import before any more lines are read.
+	StrictDDL bool
+
+	// IdempotentDDL treats a CREATE command failing because what it creates
+	// already exists as a success, so that an import which failed partway
+	// through can be run again. The query language has no IF NOT EXISTS to
+	// rewrite commands with, so the server's error is checked instead.
+	IdempotentDDL bool
+
+	// SkipDDL reads past the DDL section without executing any of its
+	// commands, for servers whose databases and retention policies are set
+	// up separately. CREATE, DROP and ALTER statements in the DML section
+	// are skipped too, rather than being written as points.
+	SkipDDL bool
+
+	// AutoCreateDatabase creates the database of each CONTEXT-DATABASE header
+	// before the first line is written to it, unless the DDL creates it. It
+	// makes dumps without a DDL section self-sufficient. A database that
+	// already exists is left as it is.
+	AutoCreateDatabase bool
+
+	// AutoCreateRetentionPolicy does the same for the retention policy of
+	// each CONTEXT-RETENTION-POLICY header, creating it with an infinite
+	// duration and a replication factor of one.
+	AutoCreateRetentionPolicy bool
+
+	// PasswordFile and PasswordEnv, if set, are a file and an environment
+	// variable to read the password from instead of passing it to
+	// NewV8Config, so that it doesn't show in process listings. Trailing
+	// newlines are trimmed from the file. The password is read when the
+	// client is created, by the first import, and only one of the three
+	// may be given.
+	PasswordFile, PasswordEnv string
+
+	// AuthToken, if set, is sent in an "Authorization: Token" header instead
+	// of basic auth credentials. It can't be combined with a username or
+	// password.
+	AuthToken string
+
+	// CompressWrites gzips the batches sent to InfluxDB, which can make
+	// imports over a slow link much faster.
+	CompressWrites bool
+
+	// Timeout limits how long each request to InfluxDB may take. Zero means
+	// no limit. Large batches on a busy server may need a generous timeout.
+	Timeout time.Duration
+
+	// WriteTimeout and QueryTimeout, if set, replace Timeout for batch
+	// writes and for DDL commands respectively, so that a slow command such
+	// as CREATE CONTINUOUS QUERY can be given longer than a write.
+	WriteTimeout time.Duration
+	QueryTimeout time.Duration
+
+	// SkipPing starts the import without pinging the server, or the
+	// replicas, for gateways that block /ping but allow writes and queries.
+	// A server that can't be reached then fails the first DDL command or
+	// batch instead. The breaker relies on pings, so it is disabled too.
+	SkipPing bool
+
+	// Headers are added to every request made to InfluxDB, such as a
+	// header that a gateway requires. They can't replace the credentials.
+	Headers map[string]string
+
+	// UnsafeSsl skips verification of the server's certificate, such as a
+	// self-signed one in a staging environment. It only applies to
+	// connections to InfluxDB, not to exports downloaded from a URL.
+	UnsafeSsl bool
+
+	// Proxy is the URL of an HTTP, HTTPS or SOCKS5 proxy that connections to
+	// InfluxDB and the replicas go through. If it is empty, the proxy in
+	// HTTP_PROXY or HTTPS_PROXY is used, if any.
+	Proxy string
+
+	// Quiet suppresses the messages logged for each failed command or batch.
+	// Failures are still counted in the summary.
+	Quiet bool
+
+	// Verbose logs every batch that is written successfully.
+	Verbose bool
+
+	// StopOnInterrupt handles SIGINT and SIGTERM while an import runs. On the
+	// first signal, no more lines are read, the lines already read are
+	// written, the summary is printed and the import returns ErrInterrupted.
+	// A second signal isn't caught. It is off by default so that a library
+	// doesn't install signal handlers its program doesn't expect.
+	StopOnInterrupt bool
+
+	// UDP sends inserts to the server's UDP listener at UDPAddr instead of
+	// over HTTP. Writes aren't acknowledged, so inserts that are lost or
+	// rejected aren't counted as failed. DDL is still executed over HTTP,
+	// and the listener's configured database is used whatever the
+	// CONTEXT-DATABASE. Timestamps must be in nanoseconds.
+	UDP     bool
+	UDPAddr string
+
+	// UDPPayloadSize is the largest packet sent when UDP is set. Zero uses
+	// client.DefaultUDPPayloadSize.
+	UDPPayloadSize int
+
+	// Output, if set, is written an export of the lines and DDL commands
+	// instead of sending them to the server, after every filter, rename,
+	// Transform and fix has been applied, to produce a cleaned dump that
+	// can be imported later. Writes to it aren't retried, and a failed write
+	// fails the import. It can't be combined with UDP, DryRun or ReplicaURLs.
+	Output io.Writer
+
+	// Generate, if set, imports the synthetic points it describes instead of
+	// reading a file, to put a server under write load. The points go
+	// through the same batching, retries and writers as an export would. It
+	// can't be combined with CheckpointFile.
+	Generate *GenerateConfig
+
+	// ReplicaURLs are further servers that every batch and DDL command is
+	// also sent to, such as a new cluster during a cutover. Writes to them
+	// are retried like writes to the main server, but a replica's failures
+	// only count against it in the summary. The import's totals, the failed
+	// lines file and checkpoints all follow the main server.
+	ReplicaURLs []url.URL
+}
+
+// NewV8Config returns an initialized *V8Config
+// A file of "-" reads the export from standard input. A stream can't report its size,
+// so progress can only be given in bytes read rather than as a percentage.
+// An http:// or https:// file is downloaded as it is imported. The InfluxDB
+// credentials aren't sent with it, but any in the URL itself are.
+// A batchSize of zero or less uses the default of 5000 lines per write.
+func NewV8Config(username, password, precision, writeConsistency, file, version string, u url.URL, compressed bool, batchSize int) *V8Config {
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	return &V8Config{
+		username:         username,
+		password:         password,
+		precision:        precision,
+		writeConsistency: writeConsistency,
+		file:             file,
+		version:          version,
+		url:              u,
+		compressed:       compressed,
+		batchSize:        batchSize,
+	}
+}
+
+// V8 is the importer used for importing 0.8 data
+type V8 struct {
+	client                                     *client.Client
+	writer                                     lineWriter // writes batches, the client unless replaced by a test
+	udp                                        *client.UDPClient
+	serverVersion                              string  // the version the server reported when pinged
+	output                                     *output // writes the import to Output instead of a server
+	replicas                                   []*replica
+	config                                     *V8Config
+	wg                                         sync.WaitGroup
+	command                                    chan command
+	done                                       chan struct{}
+	checkpointer                               *checkpointer
+	resumeFile                                 string
+	resumeLine                                 int
+	batches                                    chan lineBatch
+	deadLetter                                 *deadLetter
+	limiter                                    *limiter
+	breaker                                    *breaker
+	ddlProcessed                               bool
+	createdMu                                  sync.Mutex               // protects created
+	created                                    map[schema]chan struct{} // databases and retention policies created, closed once they exist
+	progressMu                                 sync.Mutex
+	eta                                        *etaEstimator
+	measurementsMu                             sync.Mutex // protects measurements, failureSamples and partialWrites
+	measurements                               map[string]int
+	failureSamples                             []FailureSample
+	partialWrites                              []PartialWrite
+	mu                                         sync.Mutex // protects start, end, completed, commandErr and abortErr
+	start, end                                 time.Time
+	commandErr, abortErr                       error
+	cancel                                     context.CancelFunc
+	commandSyncs                               chan chan struct{}
+	batchSeq                                   int64
+	compressedInput                            int32 // set once a compressed file has been read
+	completed                                  bool  // whether every file was read to the end
+	bytesRead, totalBytes, bytesWritten        int64
+	totalCommands, failedCommands              int64
+	totalInserts, failedInserts, failedBatches int64
+	skippedInserts, rejectedInserts            int64
+	duplicateInserts, fixedLines               int64
+	blankLines                                 int64
+	inFlightBatches                            int64
+	partialBatches                             int64
+
+	// Totals across every import, which Reset leaves alone.
+	allInserts, allFailedInserts, allCommands int64
+}
+
+// stream is the state of reading a sequence of files: the context set by
+// their headers and the batches being accumulated from their lines. Files
+// read one after another share a stream, so a file inherits the context of
+// the one before it. Each file read in parallel has a stream of its own.
+type stream struct {
+	lineContext
+
+	line    chan contextLine
+	flushes chan chan struct{}
+	done    chan struct{}
+
+	// The batches being accumulated, one for each target, and the ones
+	// holding lines in the order they were started.
+	partitions map[batchKey]*partition
+	open       []*partition
+
+	// The lines counted towards SampleRate, and hashes of the series already
+	// sampled.
+	sampleCount   int
+	sampledSeries map[uint64]struct{}
+}
+
+// lineContext is the context set by the headers of an export, which the
+// lines after them are written to.
+type lineContext struct {
+	database        string
+	retentionPolicy string
+	filePrecision   string // from the CONTEXT-PRECISION header
+}
+
+// contextLine is a line of the DML section along with the context it was
+// read in, as the context may have changed by the time it is accumulated.
+type contextLine struct {
+	sourceLine
+	lineContext
+}
+
+// batchKey is the target of a batch. Every line of a batch is written to
+// the same database and retention policy, with the same options.
+type batchKey struct {
+	database, retentionPolicy string
+	precision, consistency    string
+}
+
+// partition is the batch being accumulated for one target.
+type partition struct {
+	key   batchKey
+	lines []string
+	file  string
+	nums  []int
+	bytes int // length of lines once joined with newlines
+}
+
+// startStream returns an empty stream with its batch accumulator running.
+// The accumulator is added to accumulators, and is done once the stream's
+// done channel has been closed and its last batches handed off.
+func (v8 *V8) startStream(accumulators *sync.WaitGroup) *stream {
+	s := &stream{
+		line:       make(chan contextLine, v8.config.readAhead()),
+		flushes:    make(chan chan struct{}),
+		done:       make(chan struct{}),
+		partitions: make(map[batchKey]*partition),
+	}
+	accumulators.Add(1)
+	go func() {
+		defer accumulators.Done()
+		v8.batchAccumulator(s)
+	}()
+	return s
+}
+
+// command is a DDL command along with the database it is executed against.
+type command struct {
+	sourceLine
+	database string
+}
+
+// lineBatch is a set of lines to be written to a single database and retention policy.
+type lineBatch struct {
+	lines                     []string
+	database, retentionPolicy string
+	precision                 string
+	consistency               string // the configured one if empty
+
+	// The file the lines were read from, and the line number of each line.
+	file string
+	nums []int
+
+	// seq is the position of the batch in the order batches were made.
+	seq int
+}
+
+// location returns the range of lines the batch was read from, such as
+// "dump.txt:10-20". Lines skipped by the filters are included in the range.
+func (b lineBatch) location() string {
+	return fmt.Sprintf("%s:%d-%d", displayName(b.file), b.nums[0], b.nums[len(b.nums)-1])
+}
+
+// size returns the length of the lines of b once joined with newlines.
+func (b lineBatch) size() int64 {
+	n := int64(len(b.lines) - 1)
+	for _, l := range b.lines {
+		n += int64(len(l))
+	}
+	return n
+}
+
+// split divides the batch into two halves.
+func (b lineBatch) split() (lineBatch, lineBatch) {
+	i := len(b.lines) / 2
+	left, right := b, b
+	left.lines, left.nums = b.lines[:i], b.nums[:i]
+	right.lines, right.nums = b.lines[i:], b.nums[i:]
+	return left, right
+}
+
+// NewV8 will return an intialized V8 struct
+func NewV8(config *V8Config) *V8 {
+	return &V8{
+		config:       config,
+		done:         make(chan struct{}),
+		command:      make(chan command, config.readAhead()),
+		batches:      make(chan lineBatch, config.maxPendingBatches()),
+		commandSyncs: make(chan chan struct{}),
+	}
+}
+
+// ErrNotReset is returned when an importer that has already been used imports
+// again without Reset being called in between.
+var ErrNotReset = errors.New("importer must be Reset before importing again")
+
+// Reset clears the state left by an import so that the importer can be used
+// again, keeping its connection to the server. The totals start again from
+// zero and the next file's DDL is executed. Reset must not be called while an
+// import is running.
+func (v8 *V8) Reset() {
+	v8.done = make(chan struct{})
+	v8.command = make(chan command, v8.config.readAhead())
+	v8.batchSeq = 0
+	v8.checkpointer, v8.resumeFile, v8.resumeLine = nil, "", 0
+	v8.batches = make(chan lineBatch, v8.config.maxPendingBatches())
+	v8.commandSyncs = make(chan chan struct{})
+	v8.deadLetter, v8.limiter, v8.breaker, v8.ddlProcessed = nil, nil, nil, false
+	v8.created = nil
+	v8.totalBytes = 0
+
+	v8.mu.Lock()
+	v8.start, v8.end = time.Time{}, time.Time{}
+	v8.commandErr, v8.abortErr = nil, nil
+	v8.completed = false
+	v8.mu.Unlock()
+	atomic.StoreInt32(&v8.compressedInput, 0)
+
+	v8.measurementsMu.Lock()
+	v8.measurements, v8.failureSamples, v8.partialWrites = nil, nil, nil
+	v8.measurementsMu.Unlock()
+	for _, r := range v8.replicas {
+		r.reset()
+	}
+
+	atomic.StoreInt64(&v8.bytesRead, 0)
+	atomic.StoreInt64(&v8.bytesWritten, 0)
+	atomic.StoreInt64(&v8.totalCommands, 0)
+	atomic.StoreInt64(&v8.failedCommands, 0)
+	atomic.StoreInt64(&v8.totalInserts, 0)
+	atomic.StoreInt64(&v8.failedInserts, 0)
+	atomic.StoreInt64(&v8.failedBatches, 0)
+	atomic.StoreInt64(&v8.skippedInserts, 0)
+	atomic.StoreInt64(&v8.rejectedInserts, 0)
+	atomic.StoreInt64(&v8.duplicateInserts, 0)
+	atomic.StoreInt64(&v8.fixedLines, 0)
+	atomic.StoreInt64(&v8.blankLines, 0)
+	atomic.StoreInt64(&v8.partialBatches, 0)
+}
+
+// Client returns the client used to talk to the server, so that it can be
+// used for other queries once an import has finished, such as checking the
+// number of points written. It returns nil until an import has connected.
+// The client stays the same across imports, including after Reset.
+func (v8 *V8) Client() *client.Client {
+	return v8.client
+}
+
+// Import processes the specified file in the V8Config and writes the data to the databases in chunks specified by batchSize
+// The file may be a glob pattern, in which case every matching file is imported in sorted order.
+// An importer can only import once; Reset must be called before importing again, or ErrNotReset is returned.
+func (v8 *V8) Import() error {
+	return v8.ImportContext(context.Background())
+}
+
+// ImportContext is like Import but stops when ctx is done, returning ctx.Err().
+// See ImportFilesContext for what happens to lines that have already been read.
+func (v8 *V8) ImportContext(ctx context.Context) error {
+	return v8.ImportFilesContext(ctx, []string{v8.config.file})
+}
+
+// ImportFiles processes each of the files in order through the same client.
+// Each file may be a glob pattern, which is expanded to its sorted matches.
+// DDL is only executed from the first file that has a DDL section, and totals
+// are reported across all of the files. As with Import, Reset must be called
+// between imports.
+func (v8 *V8) ImportFiles(files []string) error {
+	return v8.ImportFilesContext(context.Background(), files)
+}
+
+// ImportFilesContext is like ImportFiles but stops reading when ctx is done
+// and returns ctx.Err(). Lines already read are still written, but a failed
+// write is no longer retried, so it returns once the writes in flight finish.
+func (v8 *V8) ImportFilesContext(ctx context.Context, files []string) (err error) {
+	if err := v8.config.validate(); err != nil {
+		return err
+	}
+
+	// The channels of the last import were closed when it finished
+	v8.mu.Lock()
+	if !v8.start.IsZero() {
+		v8.mu.Unlock()
+		return ErrNotReset
+	}
+	v8.start, v8.end = time.Now(), time.Time{}
+	v8.mu.Unlock()
+	defer v8.stopClock()
+
+	// The import is also cancelled if too many inserts fail
+	ctx, v8.cancel = context.WithCancel(ctx)
+	defer v8.cancel()
+	if v8.config.StopOnInterrupt {
+		defer v8.handleInterrupt()()
+	}
+
+	// Create a client, unless one was kept by Reset, and try to connect
+	if v8.client == nil {
+		cl, err := v8.newClient(v8.config.url)
+		if err != nil {
+			return &ConnectError{Addr: v8.config.url.Host, Err: err, msg: fmt.Sprintf("could not create client: %s", err)}
+		}
+		v8.client = cl
+	}
+	// There is no server to reach when writing to Output
+	if !v8.config.SkipPing && v8.config.Output == nil {
+		_, ver, e := v8.client.Ping()
+		if e != nil {
+			return &ConnectError{Addr: v8.client.Addr(), Err: e, msg: fmt.Sprintf("failed to connect to %s", v8.client.Addr())}
+		}
+		v8.serverVersion = ver
+	}
+	if v8.writer == nil {
+		v8.writer = v8.client
+	}
+	if err := v8.connectReplicas(); err != nil {
+		return err
+	}
+	if v8.config.Output != nil {
+		v8.output = newOutput(v8.config.Output, v8.config)
+		defer func() { v8.output = nil }()
+	}
+	if v8.config.UDP {
+		u, err := client.NewUDPClient(client.UDPConfig{Addr: v8.config.UDPAddr, PayloadSize: v8.config.UDPPayloadSize})
+		if err != nil {
+			return &ConnectError{Addr: v8.config.UDPAddr, Err: err, msg: fmt.Sprintf("could not create udp client: %s", err)}
+		}
+		v8.udp = u
+		defer func() {
+			u.Close()
+			v8.udp = nil
+		}()
+	}
+
+	// Validate args
+	if v8.config.Generate != nil {
+		files = []string{generatedFile}
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("file argument required")
+	}
+	files, err = expandFiles(files)
+	if err != nil {
+		return err
+	}
+	if v8.config.CheckpointFile != "" {
+		if files, err = v8.resume(files); err != nil {
+			return err
+		}
+	}
+	v8.totalBytes = totalSize(files)
+	v8.eta = newETAEstimator(time.Now(), v8.totalBytes)
+	v8.limiter = newLimiter(v8.config.PointsPerSecond)
+	v8.breaker = newBreaker(v8)
+
+	// Open the dead letter file before anything can fail to write
+	if v8.config.FailedLinesFile != "" {
+		d, err := newDeadLetter(v8.config.FailedLinesFile, v8.config.dmlMarker(), v8.config.precision == "")
+		if err != nil {
+			return fmt.Errorf("could not open failed lines file: %s", err)
+		}
+		v8.deadLetter = d
+		defer func() {
+			if err := d.Close(); err != nil {
+				v8.logger().Printf("error closing failed lines file: %s\n", err)
+			}
+		}()
+	}
+
+	stopProgressLog := v8.startProgressLog()
+	defer func() {
+		v8.wg.Wait()
+		if e := v8.output.flush(); e != nil && err == nil {
+			err = fmt.Errorf("writing output: %s", e)
+		}
+		stopProgressLog()
+		v8.stopClock()
+		if e := v8.abortError(); e != nil {
+			err = e
+		}
+		v8.checkpointer.finish(err == nil)
+		if err == nil {
+			err = v8.partialImportError()
+		}
+		v8.printSummary()
+	}()
+
+	// start our batch writers
+	concurrency := v8.config.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	for i := 0; i < concurrency; i++ {
+		v8.wg.Add(1)
+		go v8.batchWriter(ctx)
+	}
+
+	// start our command executor
+	v8.wg.Add(1)
+	go v8.queryExecutor()
+
+	if v8.config.FileConcurrency > 1 {
+		err = v8.importParallel(ctx, files)
+	} else {
+		err = v8.importSerial(ctx, files)
+	}
+	v8.mu.Lock()
+	v8.completed = err == nil
+	v8.mu.Unlock()
+
+	// Signal go routines we are done
+	close(v8.done)
+
+	return err
+}
+
+// importSerial imports files one after another through a single stream.
+func (v8 *V8) importSerial(ctx context.Context, files []string) (err error) {
+	var accumulators sync.WaitGroup
+	s := v8.startStream(&accumulators)
+	defer func() {
+		close(s.done)
+		accumulators.Wait()
+		close(v8.batches)
+	}()
+
+	for _, file := range files {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if err = v8.importFile(ctx, s, file, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newClient returns a client for the server at u, with the configured
+// credentials and options.
+func (v8 *V8) newClient(u url.URL) (*client.Client, error) {
+	password, err := v8.config.readPassword()
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := v8.config.proxyURL()
+	if err != nil {
+		return nil, err
+	}
+	return client.NewClient(client.Config{
+		URL:       u,
+		Username:  v8.config.username,
+		Password:  password,
+		UserAgent: fmt.Sprintf("InfluxDBImporter/%s", v8.config.version),
+		UnsafeSsl: v8.config.UnsafeSsl,
+		Proxy:     proxy,
+		AuthToken: v8.config.AuthToken,
+		Headers:   v8.config.Headers,
+		Timeout:   v8.config.Timeout,
+
+		WriteTimeout:   v8.config.WriteTimeout,
+		QueryTimeout:   v8.config.QueryTimeout,
+		CompressWrites: v8.config.CompressWrites,
+	})
+}
+
+// expandFiles replaces any glob patterns in files with the files they match.
+func expandFiles(files []string) ([]string, error) {
+	var expanded []string
+	for _, file := range files {
+		if file == "" {
+			return nil, fmt.Errorf("file argument required")
+		}
+		if isURL(file) || !strings.ContainsAny(file, "*?[") {
+			expanded = append(expanded, file)
+			continue
+		}
+		matches, err := filepath.Glob(file)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file pattern %q: %s", file, err)
+		} else if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", file)
+		}
+		sort.Strings(matches)
+		expanded = append(expanded, matches...)
+	}
+	return expanded, nil
+}
+
+// importFile reads a single file, sending its DDL to the command executor
+// and its DML to the batch accumulator of s. If turn is set, the file's DDL
+// waits for the turn and the turn is passed on once it has been executed.
+// A file that is a tar archive has each of the files in it imported instead.
+func (v8 *V8) importFile(ctx context.Context, s *stream, file string, turn *ddlTurn) error {
+	defer turn.pass()
+
+	// Open the file, read from standard input if the file is "-", or
+	// generate the points of Generate
+	var f io.ReadCloser
+	if v8.config.Generate != nil {
+		f = ioutil.NopCloser(newGenerator(*v8.config.Generate, v8.config.dmlMarker(), v8.config.precision))
+	} else if file == "-" {
+		f = ioutil.NopCloser(os.Stdin)
+	} else if isURL(file) {
+		var err error
+		if f, err = openURL(ctx, file, v8.config.ResumeDownloads, v8.logger()); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if f, err = os.Open(file); err != nil {
+			return err
+		}
+	}
+	defer f.Close()
+
+	// Wrap in a decompressing reader. The compressed flag is kept for
+	// compatibility and forces gzip.
+	compression := v8.config.Compression
+	if v8.config.compressed {
+		compression = CompressionGzip
+	}
+	// Count the bytes read before decompression, as that is what the
+	// total size is measured in
+	r, format, err := decompress(&countingReader{r: f, n: &v8.bytesRead}, compression)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if format != CompressionNone {
+		atomic.StoreInt32(&v8.compressedInput, 1)
+	}
+
+	// An archive holds the files to import, rather than being one
+	br := bufio.NewReader(r)
+	if isTar(br) {
+		return v8.importArchive(ctx, s, file, br, turn)
+	}
+	return v8.importReader(ctx, s, file, br, turn)
+}
+
+// importReader imports the content of file, read from r, as importFile does.
+func (v8 *V8) importReader(ctx context.Context, s *stream, file string, r io.Reader, turn *ddlTurn) error {
+	// Get our reader, skipping the byte order mark of files saved on Windows
+	scanner := newLineScanner(skipBOM(r), file, v8.config.maxLineBytes())
+
+	// Process the scanner
+	if err := turn.wait(ctx); err != nil {
+		return err
+	}
+	v8.processDDL(ctx, s, scanner)
+
+	// Execute every DDL command before any lines are read, so that no write
+	// reaches the server before the database it is for has been created.
+	// Under StrictDDL, don't write anything if the schema couldn't be set up.
+	if err := v8.syncCommands(); err != nil && v8.config.StrictDDL {
+		return err
+	}
+	turn.pass()
+	if err := v8.processDML(ctx, s, scanner); err != nil {
+		return err
+	}
+
+	// Don't let a batch span two files, as it only records one
+	v8.flushBatch(s)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Check if we had any errors scanning the file
+	if err := scanner.Err(); err == bufio.ErrTooLong {
+		return fmt.Errorf("line %s:%d is longer than the maximum of %d bytes, MaxLineBytes may need raising",
+			displayName(file), scanner.num, scanner.max)
+	} else if err != nil {
+		return fmt.Errorf("reading %s: %s", displayName(file), err)
+	}
+
+	return nil
+}
+
+// lineScanner is a bufio.Scanner that keeps track of the current line number.
+type lineScanner struct {
+	*bufio.Scanner
+	file string
+	num  int
+	max  int // longest line allowed, excluding its line ending
+	err  error
+}
+
+// newLineScanner returns a lineScanner reading lines of up to max bytes from r.
+func newLineScanner(r io.Reader, file string, max int) *lineScanner {
+	s := &lineScanner{Scanner: bufio.NewScanner(r), file: file, max: max}
+	// Leave room for a CRLF line ending, so the limit is the same for both endings
+	s.Buffer(nil, max+2)
+	return s
+}
+
+// Scan advances to the next line. It stops with bufio.ErrTooLong at a line
+// longer than the maximum.
+func (s *lineScanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	if !s.Scanner.Scan() {
+		// Leave num at the line that was too long to read
+		if s.Scanner.Err() == bufio.ErrTooLong {
+			s.num++
+		}
+		return false
+	}
+	s.num++
+	// Measure the scanner's bytes rather than copying them into a string
+	if len(bytes.TrimSuffix(s.Scanner.Bytes(), []byte("\r"))) > s.max {
+		s.err = bufio.ErrTooLong
+		return false
+	}
+	return true
+}
+
+// Err returns the first error encountered while scanning.
+func (s *lineScanner) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.Scanner.Err()
+}
+
+// Text returns the current line without trailing whitespace, including the
+// carriage return of a CRLF line ending. The line is copied out of the
+// scanner's buffer, which the next Scan overwrites, so it can be kept in a
+// batch. Trimming before the copy saves copying the trailing whitespace.
+func (s *lineScanner) Text() string {
+	return string(bytes.TrimRight(s.Scanner.Bytes(), " \t\r"))
+}
+
+// line returns the current line and its position in the file.
+func (s *lineScanner) line() sourceLine {
+	return sourceLine{text: s.Text(), file: s.file, num: s.num}
+}
+
+// sourceLine is a line of input along with where it was read from.
+type sourceLine struct {
+	text string
+	file string
+	num  int
+}
+
+// String returns the position of the line, such as "dump.txt:12".
+func (l sourceLine) String() string {
+	return fmt.Sprintf("%s:%d", displayName(l.file), l.num)
+}
+
+// displayName returns the name used for file in messages. The query string
+// of a URL is left out, as it may hold a signature.
+func displayName(file string) string {
+	if file == "-" {
+		return "stdin"
+	} else if isURL(file) {
+		if i := strings.IndexByte(file, '?'); i >= 0 {
+			return file[:i]
+		}
+	}
+	return file
+}
+
+func (v8 *V8) processDDL(ctx context.Context, s *stream, scanner *lineScanner) {
+	// Only the first DDL section seen is executed
+	skip := v8.config.SkipDDL
+	for scanner.Scan() {
+		line := scanner.Text()
+		// If we find the DML token, we are done with DDL
+		if strings.HasPrefix(line, v8.config.dmlMarker()) {
+			return
+		}
+		if strings.HasPrefix(line, v8.config.ddlMarker()) {
+			skip = v8.ddlProcessed || v8.config.SkipDDL
+			v8.ddlProcessed = true
+			continue
+		}
+		if line == "" {
+			atomic.AddInt64(&v8.blankLines, 1)
+			continue
+		}
+		if strings.HasPrefix(line, exportVersion) {
+			v8.checkVersion(scanner.line())
+		}
+		if strings.HasPrefix(line, "#") || skip {
+			continue
+		}
+		v8.noteCreated(line)
+		select {
+		case v8.command <- command{scanner.line(), v8.targetDatabase(s.lineContext)}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (v8 *V8) processDML(ctx context.Context, s *stream, scanner *lineScanner) error {
+	for scanner.Scan() {
+		line := scanner.Text()
+		// Each line is sent with its context, and accumulated into the batch
+		// for it, so the batches already started carry on when it changes
+		if strings.HasPrefix(line, "# CONTEXT-DATABASE:") {
+			s.database = strings.TrimSpace(strings.SplitN(line, ":", 2)[1])
+		}
+		if strings.HasPrefix(line, "# CONTEXT-RETENTION-POLICY:") {
+			s.retentionPolicy = strings.TrimSpace(strings.SplitN(line, ":", 2)[1])
+		}
+		if strings.HasPrefix(line, contextPrecision) {
+			p := strings.TrimSpace(strings.TrimPrefix(line, contextPrecision))
+			if !validPrecision(p) {
+				return fmt.Errorf("unknown precision %q at %s, expected one of n, u, ms, s, m or h", p, scanner.line())
+			}
+			s.filePrecision = p
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		// A marker that isn't a comment isn't a point either
+		if strings.HasPrefix(line, v8.config.ddlMarker()) || strings.HasPrefix(line, v8.config.dmlMarker()) {
+			continue
+		}
+		// Lines up to the checkpoint have already been imported
+		if scanner.file == v8.resumeFile && scanner.num <= v8.resumeLine {
+			continue
+		}
+		if line == "" {
+			atomic.AddInt64(&v8.blankLines, 1)
+			continue
+		}
+		if isDDL(line) {
+			if v8.config.SkipDDL {
+				continue
+			}
+			v8.noteCreated(line)
+			if err := v8.interleavedCommand(ctx, s, scanner.line()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := v8.autoCreate(s, scanner.line()); err != nil {
+			return err
+		}
+		select {
+		case s.line <- contextLine{scanner.line(), s.lineContext}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+// isDDL returns true if a line in the DML section is a CREATE, DROP or ALTER
+// statement rather than a point. A measurement can have the same name as one
+// of those keywords, so the line must also parse as the server parses it.
+func isDDL(line string) bool {
+	i := strings.IndexByte(line, ' ')
+	if i < 0 {
+		return false
+	}
+	switch strings.ToUpper(line[:i]) {
+	case "CREATE", "DROP", "ALTER":
+		_, err := influxql.ParseQuery(line)
+		return err == nil
+	}
+	return false
+}
+
+// interleavedCommand executes a DDL command found in the DML section. The
+// lines before it are handed to the writers first, and the lines after it
+// aren't read until it has been executed.
+func (v8 *V8) interleavedCommand(ctx context.Context, s *stream, c sourceLine) error {
+	v8.flushBatch(s)
+	select {
+	case v8.command <- command{c, v8.targetDatabase(s.lineContext)}:
+	case <-ctx.Done():
+		return nil
+	}
+	if err := v8.syncCommands(); err != nil && v8.config.StrictDDL {
+		return err
+	}
+	return nil
+}
+
+// execute runs command against database.
+func (v8 *V8) execute(command, database string) error {
+	// A dry run only checks that the command parses. It is parsed as a whole
+	// query, as the server does, so that trailing tokens are an error.
+	if v8.config.DryRun {
+		_, err := influxql.ParseQuery(command)
+		return err
+	}
+
+	if v8.output != nil {
+		return v8.output.writeCommand(command)
+	}
+	v8.executeReplicas(command, database)
+	response, err := v8.client.Query(client.Query{Command: command, Database: database})
+	if err != nil {
+		return v8.timeoutError(err, false)
+	}
+	return response.Error()
+}
+
+// queryExecutor executes DDL commands until the import is done. Commands
+// still buffered when a sync or done is received are executed first, as they
+// were sent before it.
+func (v8 *V8) queryExecutor() {
+	defer v8.wg.Done()
+	for {
+		select {
+		case c := <-v8.command:
+			v8.executeCommand(c)
+		case synced := <-v8.commandSyncs:
+			v8.drainCommands()
+			close(synced)
+		case <-v8.done:
+			v8.drainCommands()
+			return
+		}
+	}
+}
+
+// executeCommand executes a DDL command and records the outcome.
+func (v8 *V8) executeCommand(c command) {
+	err := v8.execute(c.text, c.database)
+	if err != nil && v8.config.IdempotentDDL && alreadyExists(err) {
+		if v8.config.Verbose {
+			v8.logger().Printf("%s: %s: %s, continuing\n", c.sourceLine, c.text, err)
+		}
+		err = nil
+	}
+	v8.commandDone(c.sourceLine, err)
+}
+
+// commandDone records the outcome of a DDL command.
+func (v8 *V8) commandDone(c sourceLine, err error) {
+	atomic.AddInt64(&v8.totalCommands, 1)
+	atomic.AddInt64(&v8.allCommands, 1)
+	if err != nil {
+		v8.logErrorf("error: %s: %s\n", c, err)
+		atomic.AddInt64(&v8.failedCommands, 1)
+		v8.mu.Lock()
+		if v8.commandErr == nil {
+			v8.commandErr = &DDLError{Command: c.text, Location: c.String(), Err: err}
+		}
+		v8.mu.Unlock()
+	}
+	v8.progress()
+}
+
+// drainCommands executes the commands waiting in the command buffer.
+func (v8 *V8) drainCommands() {
+	for {
+		select {
+		case c := <-v8.command:
+			v8.executeCommand(c)
+		default:
+			return
+		}
+	}
+}
+
+// batchAccumulator collects the lines of s into batches until s is done. As
+// with queryExecutor, lines still buffered when a flush or done is received
+// are added to their batches first.
+func (v8 *V8) batchAccumulator(s *stream) {
+	for {
+		select {
+		case l := <-s.line:
+			v8.accumulate(s, l)
+		case flushed := <-s.flushes:
+			v8.drainLines(s)
+			v8.flushAll(s)
+			close(flushed)
+		case <-s.done:
+			v8.drainLines(s)
+			// Write out whatever is left over from the last full batches
+			v8.flushAll(s)
+			return
+		}
+	}
+}
+
+// accumulate adds a line to the batch of s for its target, unless it is
+// filtered out or rejected, and hands the batch off once it is full.
+func (v8 *V8) accumulate(s *stream, l contextLine) {
+	text := l.text
+	if v8.config.FixEscaping {
+		if fixed := fixEscaping(text); fixed != text {
+			atomic.AddInt64(&v8.fixedLines, 1)
+			text = fixed
+		}
+	}
+	consistency, precision := v8.writeOptions(l.lineContext, text)
+	if !v8.included(text) || !v8.inWindow(precision, text) || !v8.sampled(s, text) {
+		atomic.AddInt64(&v8.skippedInserts, 1)
+		return
+	}
+	coerced, err := v8.coerceFields(text)
+	if err != nil {
+		v8.reject(l, text, err)
+		return
+	}
+	text = v8.rename(coerced)
+	if v8.config.Transform != nil {
+		var keep bool
+		if text, keep = v8.config.Transform(text); !keep {
+			atomic.AddInt64(&v8.skippedInserts, 1)
+			return
+		}
+	}
+	// Only lines that are to be written are validated, once transformed
+	if v8.config.ValidateLines {
+		var err error
+		if !v8.config.FixEscaping {
+			err = escapingError(text)
+		}
+		if err == nil {
+			err = v8.validateLine(precision, text)
+		}
+		if err != nil {
+			v8.reject(l, text, err)
+			return
+		}
+	}
+	p := v8.partition(s, batchKey{
+		database:        v8.targetDatabase(l.lineContext),
+		retentionPolicy: v8.targetRetentionPolicy(l.lineContext),
+		precision:       precision,
+		consistency:     consistency,
+	})
+	if max := v8.config.MaxBatchBytes; max > 0 && len(p.lines) > 0 && p.bytes+1+len(text) > max {
+		v8.flush(s, p)
+	}
+	if len(p.lines) == 0 {
+		p.file = l.file
+		s.open = append(s.open, p)
+	} else {
+		p.bytes++
+	}
+	p.lines = append(p.lines, text)
+	p.nums = append(p.nums, l.num)
+	p.bytes += len(text)
+	if len(p.lines) == v8.config.batchSize || (v8.config.MaxBatchBytes > 0 && p.bytes >= v8.config.MaxBatchBytes) {
+		v8.flush(s, p)
+	}
+}
+
+// partition returns the batch of s for key. A checkpoint only records the
+// last line written, so when there is one, batches must be written in the
+// order their lines were read, and the batches for other keys are handed off
+// first.
+func (v8 *V8) partition(s *stream, key batchKey) *partition {
+	if v8.config.CheckpointFile != "" {
+		for len(s.open) > 0 && s.open[0].key != key {
+			v8.flush(s, s.open[0])
+		}
+	}
+	p, ok := s.partitions[key]
+	if !ok {
+		p = &partition{key: key, lines: make([]string, 0, v8.config.batchSize)}
+		s.partitions[key] = p
+	}
+	return p
+}
+
+// drainLines adds the lines waiting in the line buffer of s to their batches.
+func (v8 *V8) drainLines(s *stream) {
+	for {
+		select {
+		case l := <-s.line:
+			v8.accumulate(s, l)
+		default:
+			return
+		}
+	}
+}
+
+// reject counts an invalid line and saves it to the failed lines file, if
+// there is one, so that it doesn't cause the rest of its batch to fail.
+func (v8 *V8) reject(l contextLine, text string, err error) {
+	v8.logErrorf("invalid line %s: %s\n", l.sourceLine, err)
+	atomic.AddInt64(&v8.rejectedInserts, 1)
+	if v8.deadLetter == nil {
+		return
+	}
+	_, precision := v8.writeOptions(l.lineContext, text)
+	b := lineBatch{
+		lines:           []string{text},
+		database:        v8.targetDatabase(l.lineContext),
+		retentionPolicy: v8.targetRetentionPolicy(l.lineContext),
+		precision:       precision,
+		file:            l.file,
+		nums:            []int{l.num},
+	}
+	if err := v8.deadLetter.write(b); err != nil {
+		v8.logger().Printf("error writing failed lines: %s\n", err)
+	}
+}
+
+// abort stops the import, which will return err. Only the first error is kept.
+func (v8 *V8) abort(err error) {
+	v8.mu.Lock()
+	if v8.abortErr == nil {
+		v8.abortErr = err
+	}
+	v8.mu.Unlock()
+	v8.cancel()
+}
+
+// abortError returns the error the import was aborted with, if any.
+func (v8 *V8) abortError() error {
+	v8.mu.Lock()
+	defer v8.mu.Unlock()
+	return v8.abortErr
+}
+
+// writeError returns a WriteError with the progress of the import, to abort
+// it with after failed inserts have failed.
+func (v8 *V8) writeError(failed int64) *WriteError {
+	return &WriteError{
+		FailedInserts: failed,
+		Inserts:       atomic.LoadInt64(&v8.totalInserts),
+		BytesRead:     atomic.LoadInt64(&v8.bytesRead),
+	}
+}
+
+// syncCommands waits until every command sent so far has been executed, and
+// returns the first command that failed, if any.
+func (v8 *V8) syncCommands() error {
+	synced := make(chan struct{})
+	v8.commandSyncs <- synced
+	<-synced
+
+	v8.mu.Lock()
+	defer v8.mu.Unlock()
+	return v8.commandErr
+}
+
+// targetDatabase returns the database that writes and commands read in
+// context c are sent to.
+func (v8 *V8) targetDatabase(c lineContext) string {
+	if v8.config.TargetDatabase != "" {
+		return v8.config.TargetDatabase
+	}
+	return c.database
+}
+
+// targetRetentionPolicy returns the retention policy that writes read in
+// context c are sent to.
+func (v8 *V8) targetRetentionPolicy(c lineContext) string {
+	if v8.config.TargetRetentionPolicy != "" {
+		return v8.config.TargetRetentionPolicy
+	}
+	if c.retentionPolicy == "" {
+		return v8.config.DefaultRetentionPolicy
+	}
+	return c.retentionPolicy
+}
+
+// flushBatch makes the accumulator of s hand off its partial batches, and
+// waits until it has done so. Lines sent afterwards start new batches.
+func (v8 *V8) flushBatch(s *stream) {
+	flushed := make(chan struct{})
+	s.flushes <- flushed
+	<-flushed
+}
+
+// flushAll hands off every partial batch of s, in the order they were started.
+func (v8 *V8) flushAll(s *stream) {
+	for len(s.open) > 0 {
+		v8.flush(s, s.open[0])
+	}
+}
+
+// flush hands a copy of batch p of s to the writers and resets it for reuse.
+func (v8 *V8) flush(s *stream, p *partition) {
+	if v8.config.Dedup {
+		v8.dedup(p)
+	}
+	b := lineBatch{
+		lines:           make([]string, len(p.lines)),
+		database:        p.key.database,
+		retentionPolicy: p.key.retentionPolicy,
+		precision:       p.key.precision,
+		consistency:     p.key.consistency,
+		file:            p.file,
+		nums:            make([]int, len(p.nums)),
+		seq:             int(atomic.AddInt64(&v8.batchSeq, 1) - 1),
+	}
+	copy(b.lines, p.lines)
+	copy(b.nums, p.nums)
+	atomic.AddInt64(&v8.inFlightBatches, 1)
+	v8.batches <- b
+	p.lines = p.lines[:0]
+	p.nums = p.nums[:0]
+	p.bytes = 0
+	for i, o := range s.open {
+		if o == p {
+			s.open = append(s.open[:i], s.open[i+1:]...)
+			break
+		}
+	}
+}
+
+// dedup removes repeated lines from batch p. The last of each is kept, so
+// the batch still ends at the last line read and checkpoints stay accurate.
+func (v8 *V8) dedup(p *partition) {
+	last := make(map[string]int, len(p.lines))
+	for i, l := range p.lines {
+		last[l] = i
+	}
+	n := 0
+	for i, l := range p.lines {
+		if last[l] != i {
+			continue
+		}
+		p.lines[n], p.nums[n] = l, p.nums[i]
+		n++
+	}
+	atomic.AddInt64(&v8.duplicateInserts, int64(len(p.lines)-n))
+	p.lines, p.nums = p.lines[:n], p.nums[:n]
+}
+
+// batchWriter writes batches handed off by the accumulator until there are no more.
+func (v8 *V8) batchWriter(ctx context.Context) {
+	defer v8.wg.Done()
+	for b := range v8.batches {
+		waitReplicas := v8.writeReplicas(ctx, b)
+		r := v8.writeBatch(ctx, b)
+		waitReplicas()
+		atomic.AddInt64(&v8.inFlightBatches, -1)
+		// A batch that failed because the import was cancelled should be
+		// written again when it is resumed
+		if r.failedInserts == 0 || ctx.Err() == nil {
+			v8.checkpointer.done(b.seq, b, r)
+		}
+		v8.progress()
+	}
+}
+
+// writeBatch writes a batch and records the outcome. If BisectOnFailure is
+// set and the server rejected the batch, each half is written separately so
+// that only the lines it rejects are failed.
+func (v8 *V8) writeBatch(ctx context.Context, b lineBatch) batchResult {
+	resp, err := v8.writeWithRetry(ctx, b)
+	if dropped, reason, ok := partialWrite(resp, err, len(b.lines)); ok {
+		return v8.partialBatch(b, dropped, reason)
+	} else if err == nil {
+		atomic.AddInt64(&v8.totalInserts, int64(len(b.lines)))
+		atomic.AddInt64(&v8.allInserts, int64(len(b.lines)))
+		atomic.AddInt64(&v8.bytesWritten, b.size())
+		v8.countMeasurements(b.lines)
+		if v8.config.Verbose {
+			v8.logger().Printf("wrote %d lines to %s.%s\n", len(b.lines), b.database, b.retentionPolicy)
+		}
+		return batchResult{inserts: len(b.lines)}
+	}
+
+	// Splitting only helps if the content was at fault, not the server
+	rejected := v8.config.DryRun || !retryable(resp)
+	if v8.config.BisectOnFailure && rejected && len(b.lines) > 1 && ctx.Err() == nil {
+		v8.logErrorf("error writing batch %s, splitting it: %s\n", b.location(), err)
+		left, right := b.split()
+		l, r := v8.writeBatch(ctx, left), v8.writeBatch(ctx, right)
+		return batchResult{
+			inserts:       l.inserts + r.inserts,
+			failedInserts: l.failedInserts + r.failedInserts,
+			failedBatches: l.failedBatches + r.failedBatches,
+		}
+	}
+
+	v8.logErrorf("error writing batch %s: %s\n", b.location(), err)
+	v8.sampleFailures(b, err)
+	failed := atomic.AddInt64(&v8.failedInserts, int64(len(b.lines)))
+	atomic.AddInt64(&v8.allFailedInserts, int64(len(b.lines)))
+	atomic.AddInt64(&v8.failedBatches, 1)
+	v8.checkFailures(b, err, failed)
+	if v8.deadLetter != nil {
+		if err := v8.deadLetter.write(b); err != nil {
+			v8.logger().Printf("error writing failed lines: %s\n", err)
+		}
+	}
+	return batchResult{failedInserts: len(b.lines), failedBatches: 1}
+}
+
+// checkFailures aborts the import if b, which failed with err, was the first
+// failure that StopOnWriteError stops at, or took the failed inserts past
+// MaxFailedInserts.
+func (v8 *V8) checkFailures(b lineBatch, err error, failed int64) {
+	if v8.config.StopOnWriteError {
+		e := v8.writeError(failed)
+		e.Batch, e.Err = b.location(), err
+		e.msg = fmt.Sprintf("aborted after batch %s failed: %s: %d inserts written, %d bytes read",
+			e.Batch, strings.TrimSpace(err.Error()), e.Inserts, e.BytesRead)
+		v8.abort(e)
+	} else if max := v8.config.MaxFailedInserts; max > 0 && failed > int64(max) {
+		e := v8.writeError(failed)
+		e.msg = fmt.Sprintf("aborted after %d failed inserts: %d inserts written, %d bytes read",
+			failed, e.Inserts, e.BytesRead)
+		v8.abort(e)
+	}
+}
+
+// writeWithRetry writes a batch, retrying retryable failures up to MaxRetries
+// times with a doubling backoff. It returns the response and error of the last
+// attempt if all attempts fail, or if ctx is done before the next attempt.
+func (v8 *V8) writeWithRetry(ctx context.Context, b lineBatch) (*client.Response, error) {
+	if v8.config.DryRun {
+		return nil, v8.validate(b)
+	}
+
+	backoff := v8.config.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	resumed := false
+	for attempt := 0; ; attempt++ {
+		v8.breaker.wait(ctx)
+		v8.limiter.wait(ctx, len(b.lines))
+		if v8.config.BeforeBatch != nil {
+			v8.config.BeforeBatch(len(b.lines))
+		}
+		start := time.Now()
+		resp, err := v8.batchWrite(b, v8.consistency(b, attempt))
+		if v8.config.AfterBatch != nil {
+			v8.config.AfterBatch(len(b.lines), err, time.Since(start))
+		}
+		if err == nil {
+			v8.breaker.success()
+			return resp, err
+		}
+		if !retryable(resp) {
+			return resp, err
+		}
+		if attempt >= v8.config.MaxRetries {
+			// A batch that failed while the server was down is written once
+			// more when it comes back
+			if !resumed && v8.breaker.failure(ctx) {
+				resumed, attempt = true, -1
+				continue
+			}
+			return resp, err
+		}
+		delay := v8.config.retryDelay(backoff)
+		if next := v8.consistency(b, attempt+1); next != v8.consistency(b, attempt) {
+			v8.logErrorf("error writing batch, retrying in %s with consistency %s: %s\n", delay, next, err)
+		} else {
+			v8.logErrorf("error writing batch, retrying in %s: %s\n", delay, err)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return resp, err
+		}
+		backoff *= 2
+	}
+}
+
+// lineWriter writes lines of line protocol to a database. It is implemented
+// by *client.Client, and lets tests record batches without a server.
+type lineWriter interface {
+	WriteLines(lines []string, database, retentionPolicy, precision, writeConsistency string) (*client.Response, error)
+}
+
+// consistency returns the write consistency of the given attempt at writing
+// b. Retries use the FallbackConsistency, if there is one.
+func (v8 *V8) consistency(b lineBatch, attempt int) string {
+	if attempt > 0 && v8.config.FallbackConsistency != "" {
+		return v8.config.FallbackConsistency
+	} else if b.consistency != "" {
+		return b.consistency
+	}
+	return v8.config.writeConsistency
+}
+
+func (v8 *V8) batchWrite(b lineBatch, consistency string) (*client.Response, error) {
+	if v8.udp != nil {
+		if b.precision != "" && b.precision != "n" {
+			return &client.Response{}, fmt.Errorf("can't write timestamps with precision %s over udp", b.precision)
+		}
+		return nil, v8.udp.WriteLineProtocol(strings.Join(v8.body(b), "\n"))
+	}
+	if v8.output != nil {
+		return v8.output.WriteLines(v8.body(b), b.database, b.retentionPolicy, b.precision, consistency)
+	}
+	resp, err := v8.writer.WriteLines(v8.body(b), b.database, b.retentionPolicy, b.precision, consistency)
+	return resp, v8.timeoutError(err, true)
+}
+
+// body returns the lines of b in the order they are sent to the server.
+func (v8 *V8) body(b lineBatch) []string {
+	if v8.config.SortBatch {
+		return sortLines(b.lines)
+	}
+	return b.lines
+}
+
+// timeoutError makes a request that timed out say so, as the error from the
+// http client doesn't make it obvious. write is true for a batch write and
+// false for a command.
+func (v8 *V8) timeoutError(err error, write bool) error {
+	e, ok := err.(net.Error)
+	if !ok || !e.Timeout() {
+		return err
+	}
+	timeout, option := v8.config.Timeout, "Timeout"
+	if write && v8.config.WriteTimeout > 0 {
+		timeout, option = v8.config.WriteTimeout, "WriteTimeout"
+	} else if !write && v8.config.QueryTimeout > 0 {
+		timeout, option = v8.config.QueryTimeout, "QueryTimeout"
+	}
+	return fmt.Errorf("request timed out after %s, the %s may need raising: %s", timeout, option, err)
+}
+
+// validate parses a batch the same way the server would, without writing it.
+func (v8 *V8) validate(b lineBatch) error {
+	_, err := tsdb.ParsePointsWithPrecision([]byte(strings.Join(b.lines, "\n")), time.Now().UTC(), parsePrecision(b.precision))
+	return err
+}
+
+// validateLine parses a single line the same way the server would.
+func (v8 *V8) validateLine(precision, line string) error {
+	_, err := tsdb.ParsePointsWithPrecision([]byte(line), time.Now().UTC(), parsePrecision(precision))
+	return err
+}
+
+// maxLineBytes returns the longest line that can be scanned.
+func (c *V8Config) maxLineBytes() int {
+	if c.MaxLineBytes <= 0 {
+		return defaultMaxLineBytes
+	}
+	return c.MaxLineBytes
+}
+
+// readAhead returns the size of the line and command buffers.
+func (c *V8Config) readAhead() int {
+	if c.ReadAhead < 0 {
+		return 0
+	} else if c.ReadAhead == 0 {
+		return c.batchSize
+	}
+	return c.ReadAhead
+}
+
+// ddlMarker returns the line that starts the DDL section.
+func (c *V8Config) ddlMarker() string {
+	if c.DDLMarker == "" {
+		return "# DDL"
+	}
+	return c.DDLMarker
+}
+
+// dmlMarker returns the line that starts the DML section.
+func (c *V8Config) dmlMarker() string {
+	if c.DMLMarker == "" {
+		return "# DML"
+	}
+	return c.DMLMarker
+}
+
+// maxPendingBatches returns the number of batches that may wait for a writer.
+func (c *V8Config) maxPendingBatches() int {
+	if c.MaxPendingBatches < 0 {
+		return 0
+	}
+	return c.MaxPendingBatches
+}
+
+// validate checks the settings that would otherwise only be rejected by the
+// server, once every batch had been sent.
+func (c *V8Config) validate() error {
+	if c.precision != "" && !validPrecision(c.precision) {
+		return fmt.Errorf("unknown precision %q, expected one of n, u, ms, s, m or h", c.precision)
+	}
+	if c.Generate != nil {
+		if c.CheckpointFile != "" {
+			return fmt.Errorf("a checkpoint can't be used with Generate, as generated points can't be resumed")
+		}
+		if err := c.Generate.validate(); err != nil {
+			return err
+		}
+	}
+	if c.FileConcurrency > 1 && c.CheckpointFile != "" {
+		return fmt.Errorf("a checkpoint can't be used with FileConcurrency, as files read at once have no single position to resume from")
+	}
+	if (c.PasswordFile != "" && c.PasswordEnv != "") || (c.password != "" && (c.PasswordFile != "" || c.PasswordEnv != "")) {
+		return fmt.Errorf("only one of a password, PasswordFile and PasswordEnv can be given")
+	}
+	if !validJitter(c.RetryJitter) {
+		return fmt.Errorf("unknown retry jitter %q, expected equal, none or empty for full jitter", c.RetryJitter)
+	}
+	for field, t := range c.FieldTypeHints {
+		if !validFieldType(t) {
+			return fmt.Errorf("unknown type %q for field %s, expected one of integer, float, string or boolean", t, field)
+		}
+	}
+	if c.Output != nil && (c.UDP || c.DryRun || len(c.ReplicaURLs) > 0) {
+		return fmt.Errorf("Output can't be used with UDP, DryRun or replicas, as nothing is sent to a server")
+	}
+	if c.UDP && len(c.ReplicaURLs) > 0 {
+		return fmt.Errorf("replicas can't be used with UDP")
+	}
+	if c.UDP && c.precision != "" && c.precision != "n" {
+		return fmt.Errorf("precision %q can't be used with UDP, which only accepts nanoseconds", c.precision)
+	}
+	consistencies := []string{c.writeConsistency, c.FallbackConsistency}
+	for m, o := range c.MeasurementOverrides {
+		if o.Precision != "" && !validPrecision(o.Precision) {
+			return fmt.Errorf("unknown precision %q for measurement %s, expected one of n, u, ms, s, m or h", o.Precision, m)
+		} else if c.UDP && o.Precision != "" && o.Precision != "n" {
+			return fmt.Errorf("precision %q for measurement %s can't be used with UDP, which only accepts nanoseconds", o.Precision, m)
+		}
+		consistencies = append(consistencies, o.Consistency)
+	}
+	for _, consistency := range consistencies {
+		switch strings.ToLower(consistency) {
+		case "", client.ConsistencyAny, client.ConsistencyOne, client.ConsistencyQuorum, client.ConsistencyAll:
+		default:
+			return fmt.Errorf("unknown write consistency %q, expected one of any, one, quorum or all", consistency)
+		}
+	}
+	if _, err := c.proxyURL(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// proxyURL returns the configured Proxy, or nil if none is set.
+func (c *V8Config) proxyURL() (*url.URL, error) {
+	if c.Proxy == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(c.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %s", c.Proxy, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q, expected a URL such as http://proxy:3128", c.Proxy)
+	}
+	return u, nil
+}
+
+// readPassword returns the password, read from PasswordFile or PasswordEnv
+// if one of them is set.
+func (c *V8Config) readPassword() (string, error) {
+	switch {
+	case c.PasswordFile != "":
+		b, err := ioutil.ReadFile(c.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("reading password: %s", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	case c.PasswordEnv != "":
+		password, ok := os.LookupEnv(c.PasswordEnv)
+		if !ok {
+			return "", fmt.Errorf("reading password: %s is not set", c.PasswordEnv)
+		}
+		return password, nil
+	}
+	return c.password, nil
+}
+
+// contextPrecision is the header giving the precision of the timestamps that follow it.
+const contextPrecision = "# CONTEXT-PRECISION:"
+
+// validPrecision returns true if p is a precision the server accepts.
+func validPrecision(p string) bool {
+	switch p {
+	case "n", "u", "ms", "s", "m", "h":
+		return true
+	}
+	return false
+}
+
+// writePrecision returns the precision that writes read in context c are sent
+// with. The configured precision takes priority over a CONTEXT-PRECISION header.
+func (v8 *V8) writePrecision(c lineContext) string {
+	if v8.config.precision != "" {
+		return v8.config.precision
+	}
+	return c.filePrecision
+}
+
+// parsePrecision returns the precision timestamps are parsed with when
+// writes are sent with precision p.
+func parsePrecision(p string) string {
+	if p == "" {
+		return "n"
+	}
+	return p
+}
+
+// alreadyExists returns true if err is the server saying that what a CREATE
+// command was to create already exists.
+func alreadyExists(err error) bool {
+	switch err.Error() {
+	case "database already exists", "retention policy already exists",
+		"continuous query already exists", "user already exists":
+		return true
+	}
+	return false
+}
+
+// retryable returns true if a failed write may succeed when sent again.
+// A nil response means the request never completed, e.g. a network error.
+func retryable(resp *client.Response) bool {
+	return resp == nil || resp.StatusCode >= 500
+}
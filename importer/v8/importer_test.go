@@ -0,0 +1,4328 @@
+package v8_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/client"
+	"github.com/influxdb/influxdb/importer/v8"
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/tsdb"
+)
+
+// Ensure that a batch size of one issues a write for every line.
+func TestV8_Import_BatchSizeOne(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	i := v8.NewV8(v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 1))
+	if err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(s.Writes()); n != 3 {
+		t.Fatalf("unexpected write count: %d", n)
+	}
+	for _, w := range s.Writes() {
+		if strings.Contains(w, "\n") {
+			t.Fatalf("expected a single line per write, got %q", w)
+		}
+	}
+}
+
+// Ensure that the default batch size is used when none is specified.
+func TestV8_Import_DefaultBatchSize(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	i := v8.NewV8(v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0))
+	if err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	writes := s.Writes()
+	if len(writes) != 1 {
+		t.Fatalf("unexpected write count: %d", len(writes))
+	} else if n := len(strings.Split(writes[0], "\n")); n != 3 {
+		t.Fatalf("unexpected line count: %d", n)
+	}
+}
+
+// Ensure that concurrent writers receive every batch exactly once.
+func TestV8_Import_Concurrency(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 1)
+	config.Concurrency = 4
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	writes := s.Writes()
+	sort.Strings(writes)
+	if exp := []string{
+		"cpu,host=server01 value=1 1434055562000000000",
+		"cpu,host=server02 value=2 1434055562000000000",
+		"mem,host=server01 value=3 1434055562000000000",
+	}; !reflect.DeepEqual(writes, exp) {
+		t.Fatalf("unexpected writes:\n\nexp=%#v\n\ngot=%#v", exp, writes)
+	}
+}
+
+// Ensure that the final partial batch is always written before Import returns.
+func TestV8_Import_FlushesFinalBatch(t *testing.T) {
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\ncpu value=1\n")
+	defer os.Remove(path)
+
+	for i := 0; i < 100; i++ {
+		s := NewServer()
+		if err := v8.NewV8(v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)).Import(); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(s.Writes()); n != 1 {
+			t.Fatalf("run %d: unexpected write count: %d", i, n)
+		}
+		s.Close()
+	}
+}
+
+// Ensure that server errors are retried until the write succeeds.
+func TestV8_Import_RetryServerError(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteStatus = func(n int) int {
+		if n < 2 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusNoContent
+	}
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.MaxRetries = 2
+	config.RetryBackoff = time.Millisecond
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := s.Attempts(); n != 3 {
+		t.Fatalf("unexpected write attempts: %d", n)
+	} else if n := len(s.Writes()); n != 1 {
+		t.Fatalf("unexpected write count: %d", n)
+	}
+}
+
+// Ensure that retries are written with the FallbackConsistency.
+func TestV8_Import_FallbackConsistency(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteStatus = func(n int) int {
+		if n < 1 {
+			return http.StatusInternalServerError
+		}
+		return http.StatusNoContent
+	}
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	var logger Logger
+	config := v8.NewV8Config("", "", "", client.ConsistencyQuorum, path, "test", s.URL(), false, 0)
+	config.MaxRetries = 1
+	config.RetryBackoff = time.Millisecond
+	config.FallbackConsistency = client.ConsistencyAny
+	config.Logger = &logger
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := s.Attempts(); n != 2 {
+		t.Fatalf("unexpected write attempts: %d", n)
+	} else if c := s.WriteParams()[0].Get("consistency"); c != client.ConsistencyAny {
+		t.Fatalf("unexpected consistency: %q", c)
+	} else if m := strings.Join(logger.Messages(), ""); !strings.Contains(m, "with consistency any") {
+		t.Fatalf("unexpected messages: %q", m)
+	}
+}
+
+// Ensure that measurements with overrides are written in batches of their own,
+// with their own consistency and precision.
+func TestV8_Import_MeasurementOverrides(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\n" +
+		"cpu value=1 1\ncpu value=2 2\nmem value=3 3\nmem value=4 4\ncpu value=5 5\n")
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", client.ConsistencyAny, path, "test", s.URL(), false, 10)
+	config.MeasurementOverrides = map[string]v8.WriteOverride{
+		"mem": {Consistency: client.ConsistencyQuorum, Precision: "s"},
+	}
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"cpu value=1 1\ncpu value=2 2\ncpu value=5 5", "mem value=3 3\nmem value=4 4"}
+	if w := s.Writes(); !reflect.DeepEqual(w, exp) {
+		t.Fatalf("unexpected writes: %q", w)
+	}
+	params := s.WriteParams()
+	for i, exp := range [][2]string{{"any", ""}, {"quorum", "s"}} {
+		if c, p := params[i].Get("consistency"), params[i].Get("precision"); c != exp[0] || p != exp[1] {
+			t.Fatalf("unexpected consistency and precision of write %d: %q, %q", i, c, p)
+		}
+	}
+
+	config.MeasurementOverrides["mem"] = v8.WriteOverride{Consistency: "most"}
+	if err := v8.NewV8(config).Import(); err == nil || !strings.Contains(err.Error(), `unknown write consistency "most"`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that client errors are not retried.
+func TestV8_Import_NoRetryClientError(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteStatus = func(n int) int { return http.StatusBadRequest }
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.MaxRetries = 3
+	config.RetryBackoff = time.Millisecond
+	if err := v8.NewV8(config).Import(); !errors.Is(err, v8.ErrPartialImport) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if n := s.Attempts(); n != 1 {
+		t.Fatalf("unexpected write attempts: %d", n)
+	}
+}
+
+// Ensure that the points a server drops from a partial write are counted as
+// failed, whether it accepted the write or refused it.
+func TestV8_Import_PartialWrite(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteStatus = func(n int) int {
+		switch n {
+		case 1:
+			return http.StatusOK
+		case 2:
+			return http.StatusBadRequest
+		}
+		return http.StatusNoContent
+	}
+	s.WriteBody = func(n int) string {
+		switch n {
+		case 1:
+			return `{"error":"partial write: field type conflict dropped=1"}`
+		case 2:
+			return `{"error":"partial write: unable to parse 'cpu value=': dropped=1"}`
+		}
+		return ""
+	}
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\n" +
+		"cpu value=1\ncpu value=2\ncpu value=3\ncpu value=\"4\"\ncpu value=5\ncpu value=\n")
+	defer os.Remove(path)
+
+	var logger Logger
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 2)
+	config.Logger = &logger
+	i := v8.NewV8(config)
+	if err := i.Import(); !errors.Is(err, v8.ErrPartialImport) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sum := i.Summary()
+	if sum.TotalInserts != 4 || sum.FailedInserts != 2 || sum.FailedBatches != 0 || sum.PartialBatches != 2 {
+		t.Fatalf("unexpected summary: %+v", sum)
+	}
+	// Only the batch that was written in full is known line by line
+	if sum.Measurements["cpu"] != 2 || sum.BytesWritten != int64(len("cpu value=1\ncpu value=2")) {
+		t.Fatalf("unexpected measurements and bytes written: %v, %d", sum.Measurements, sum.BytesWritten)
+	}
+	exp := []v8.PartialWrite{
+		{Location: path + ":5-6", Dropped: 1, Reason: "partial write: field type conflict dropped=1"},
+		{Location: path + ":7-8", Dropped: 1, Reason: "partial write: unable to parse 'cpu value=': dropped=1"},
+	}
+	if !reflect.DeepEqual(sum.PartialWrites, exp) {
+		t.Fatalf("unexpected partial writes: %+v", sum.PartialWrites)
+	} else if m := strings.Join(logger.Messages(), ""); !strings.Contains(m, "Partially wrote 2 batches") {
+		t.Fatalf("unexpected messages: %q", m)
+	}
+}
+
+// Ensure that lines which can't be written are saved in a replayable file.
+func TestV8_Import_FailedLinesFile(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteStatus = func(n int) int { return http.StatusBadRequest }
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+	failed := MustWriteTempFile("")
+	defer os.Remove(failed)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.FailedLinesFile = failed
+	if err := v8.NewV8(config).Import(); !errors.Is(err, v8.ErrPartialImport) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := `# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:rp0
+# ` + path + `:8-10
+cpu,host=server01 value=1 1434055562000000000
+cpu,host=server02 value=2 1434055562000000000
+mem,host=server01 value=3 1434055562000000000
+`; string(b) != exp {
+		t.Fatalf("unexpected failed lines:\n\nexp=%s\n\ngot=%s", exp, b)
+	}
+
+	// Replaying the file should write every line.
+	replay := NewServer()
+	defer replay.Close()
+	if err := v8.NewV8(v8.NewV8Config("", "", "", "", failed, "test", replay.URL(), false, 0)).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(replay.Writes()); n != 1 {
+		t.Fatalf("unexpected replay write count: %d", n)
+	}
+}
+
+// Ensure that the failed lines file goes back to nanoseconds after lines
+// written with another precision, including when it is appended to, so that
+// replaying it writes the points that failed.
+func TestV8_Import_FailedLinesFile_PrecisionReset(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteStatus = func(n int) int { return http.StatusBadRequest }
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\nmem value=1 1\ncpu value=2 2000000000\n")
+	defer os.Remove(path)
+	failed := MustWriteTempFile("")
+	defer os.Remove(failed)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.FailedLinesFile = failed
+	config.MeasurementOverrides = map[string]v8.WriteOverride{"mem": {Precision: "s"}}
+	if err := v8.NewV8(config).Import(); !errors.Is(err, v8.ErrPartialImport) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	config.MeasurementOverrides = nil
+	if err := v8.NewV8(config).Import(); !errors.Is(err, v8.ErrPartialImport) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := `# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-PRECISION:s
+# ` + path + `:3-3
+mem value=1 1
+# CONTEXT-PRECISION:n
+# ` + path + `:4-4
+cpu value=2 2000000000
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-PRECISION:n
+# ` + path + `:3-4
+mem value=1 1
+cpu value=2 2000000000
+`; string(b) != exp {
+		t.Fatalf("unexpected failed lines:\n\nexp=%s\n\ngot=%s", exp, b)
+	}
+
+	replay := NewServer()
+	defer replay.Close()
+	if err := v8.NewV8(v8.NewV8Config("", "", "", "", failed, "test", replay.URL(), false, 0)).Import(); err != nil {
+		t.Fatal(err)
+	}
+	var got []time.Time
+	for _, p := range replay.points("db0", "") {
+		got = append(got, p.Time())
+	}
+	if exp := []time.Time{time.Unix(1, 0), time.Unix(2, 0), time.Unix(0, 1), time.Unix(2, 0)}; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected times: %v", got)
+	}
+}
+
+// Ensure that a file of "-" reads the export from standard input.
+func TestV8_Import_Stdin(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stdin := os.Stdin
+	os.Stdin = f
+	defer func() { os.Stdin = stdin }()
+
+	if err := v8.NewV8(v8.NewV8Config("", "", "", "", "-", "test", s.URL(), false, 0)).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.Writes()); n != 1 {
+		t.Fatalf("unexpected write count: %d", n)
+	}
+}
+
+// Ensure that a byte order mark at the start of a file, compressed or not,
+// doesn't stop the first DDL command being recognized.
+func TestV8_Import_BOM(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/dump_bom.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := MustWriteTempGzipFile(string(b))
+	defer os.Remove(gz)
+
+	for _, path := range []string{"testdata/dump_bom.txt", gz} {
+		s := NewServer()
+		if err := v8.NewV8(v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)).Import(); err != nil {
+			t.Fatal(err)
+		}
+		if exp := []string{"CREATE DATABASE db0", "CREATE RETENTION POLICY rp0 ON db0 DURATION 1h REPLICATION 1"}; !reflect.DeepEqual(s.Queries(), exp) {
+			t.Fatalf("unexpected queries: %#v", s.Queries())
+		}
+		if writes := s.Writes(); len(writes) != 1 || len(strings.Split(writes[0], "\n")) != 3 {
+			t.Fatalf("unexpected writes: %#v", writes)
+		}
+		s.Close()
+	}
+}
+
+// Ensure that gzip content is detected without the compressed flag.
+func TestV8_Import_DetectGzip(t *testing.T) {
+	for _, path := range []string{MustWriteTempFile(dump), MustWriteTempGzipFile(dump)} {
+		defer os.Remove(path)
+
+		s := NewServer()
+		if err := v8.NewV8(v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)).Import(); err != nil {
+			t.Fatal(err)
+		}
+		if writes := s.Writes(); len(writes) != 1 {
+			t.Fatalf("unexpected write count: %d", len(writes))
+		} else if n := len(strings.Split(writes[0], "\n")); n != 3 {
+			t.Fatalf("unexpected line count: %d", n)
+		}
+		s.Close()
+	}
+}
+
+// Ensure that every member of a file of concatenated gzip members is imported.
+func TestV8_Import_GzipMultistream(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	config := v8.NewV8Config("", "", "", "", "testdata/dump_multistream.txt.gz", "test", s.URL(), false, 0)
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if writes := s.Writes(); len(writes) != 1 {
+		t.Fatalf("unexpected write count: %d", len(writes))
+	} else if n := len(strings.Split(writes[0], "\n")); n != 3 {
+		t.Fatalf("unexpected line count: %d", n)
+	}
+}
+
+// Ensure that each data file in a tar archive is imported, detecting the
+// compression of each, and that other entries are skipped.
+func TestV8_Import_TarArchive(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range []struct {
+		name    string
+		typ     byte
+		content string
+	}{
+		{"backup/", tar.TypeDir, ""},
+		{"backup/db0.txt", tar.TypeReg, dump},
+		{"backup/._db0.txt", tar.TypeReg, "\x00\x05\x16\x07"},
+		{"backup/db1.txt.gz", tar.TypeReg, MustGzip("# DML\n# CONTEXT-DATABASE:db1\ncpu value=4 1434055562000000000\n")},
+		{"backup/latest", tar.TypeSymlink, ""},
+	} {
+		h := &tar.Header{Name: e.name, Typeflag: e.typ, Mode: 0644, Size: int64(len(e.content))}
+		if e.typ == tar.TypeSymlink {
+			h.Linkname = "db0.txt"
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		} else if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := MustWriteTempGzipFile(buf.String())
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	i := v8.NewV8(config)
+	if err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+	if w := s.Writes(); len(w) != 2 || w[1] != "cpu value=4 1434055562000000000" {
+		t.Fatalf("unexpected writes: %q", w)
+	} else if p := s.WriteParams(); p[0].Get("db") != "db0" || p[1].Get("db") != "db1" {
+		t.Fatalf("unexpected write params: %v", p)
+	} else if sum := i.Summary(); sum.TotalInserts != 4 || sum.FailedInserts != 0 {
+		t.Fatalf("unexpected summary: %+v", sum)
+	}
+
+	config.CheckpointFile = path + ".checkpoint"
+	defer os.Remove(config.CheckpointFile)
+	if err := v8.NewV8(config).Import(); err == nil || !strings.Contains(err.Error(), "is an archive") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that bzip2 files can be imported, whether selected or detected.
+func TestV8_Import_Bzip2(t *testing.T) {
+	for _, compression := range []string{v8.CompressionBzip2, v8.CompressionAuto} {
+		s := NewServer()
+		config := v8.NewV8Config("", "", "", "", "testdata/dump.txt.bz2", "test", s.URL(), false, 0)
+		config.Compression = compression
+		if err := v8.NewV8(config).Import(); err != nil {
+			t.Fatal(err)
+		}
+		if writes := s.Writes(); len(writes) != 1 {
+			t.Fatalf("unexpected write count: %d", len(writes))
+		} else if n := len(strings.Split(writes[0], "\n")); n != 3 {
+			t.Fatalf("unexpected line count: %d", n)
+		}
+		s.Close()
+	}
+}
+
+// Ensure that zstd files can be imported, whether selected or detected.
+func TestV8_Import_Zstd(t *testing.T) {
+	for _, compression := range []string{v8.CompressionZstd, v8.CompressionAuto} {
+		s := NewServer()
+		config := v8.NewV8Config("", "", "", "", "testdata/dump.txt.zst", "test", s.URL(), false, 0)
+		config.Compression = compression
+		if err := v8.NewV8(config).Import(); err != nil {
+			t.Fatal(err)
+		}
+		if writes := s.Writes(); len(writes) != 1 {
+			t.Fatalf("unexpected write count: %d", len(writes))
+		} else if n := len(strings.Split(writes[0], "\n")); n != 3 {
+			t.Fatalf("unexpected line count: %d", n)
+		}
+		s.Close()
+	}
+}
+
+// Ensure that a truncated zstd file returns an error.
+func TestV8_Import_ZstdTruncated(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	b, err := ioutil.ReadFile("testdata/dump.txt.zst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := MustWriteTempFile(string(b[:len(b)/2]))
+	defer os.Remove(path)
+
+	if err := v8.NewV8(v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)).Import(); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// Ensure that several files are imported through one importer, with DDL
+// only executed from the first file that has a DDL section.
+func TestV8_ImportFiles(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	first := MustWriteTempFile(dump)
+	defer os.Remove(first)
+	second := MustWriteTempFile(`# DDL
+CREATE DATABASE db1
+
+# DML
+# CONTEXT-DATABASE:db1
+disk,host=server01 value=4 1434055562000000000
+`)
+	defer os.Remove(second)
+
+	if err := v8.NewV8(v8.NewV8Config("", "", "", "", "", "test", s.URL(), false, 0)).ImportFiles([]string{first, second}); err != nil {
+		t.Fatal(err)
+	}
+
+	if writes := s.Writes(); len(writes) != 2 {
+		t.Fatalf("unexpected write count: %d", len(writes))
+	} else if writes[1] != "disk,host=server01 value=4 1434055562000000000" {
+		t.Fatalf("unexpected write: %q", writes[1])
+	}
+	if params := s.WriteParams(); params[0].Get("db") != "db0" || params[1].Get("db") != "db1" {
+		t.Fatalf("unexpected write databases: %q, %q", params[0].Get("db"), params[1].Get("db"))
+	}
+	for _, q := range s.Queries() {
+		if strings.Contains(q, "db1") {
+			t.Fatalf("unexpected query from second DDL section: %q", q)
+		}
+	}
+}
+
+// Ensure that an importer can be reused after Reset, starting its totals and
+// DDL afresh for the next file.
+func TestV8_Reset(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	first := MustWriteTempFile(dump)
+	defer os.Remove(first)
+	second := MustWriteTempFile(`# DDL
+CREATE DATABASE db1
+
+# DML
+# CONTEXT-DATABASE:db1
+disk,host=server01 value=4 1434055562000000000
+`)
+	defer os.Remove(second)
+
+	i := v8.NewV8(v8.NewV8Config("", "", "", "", "", "test", s.URL(), false, 0))
+	if err := i.ImportFiles([]string{first}); err != nil {
+		t.Fatal(err)
+	} else if sum := i.Summary(); sum.TotalInserts != 3 {
+		t.Fatalf("unexpected first summary: %#v", sum)
+	}
+
+	if err := i.ImportFiles([]string{second}); err != v8.ErrNotReset {
+		t.Fatalf("unexpected error importing again without reset: %v", err)
+	}
+
+	i.Reset()
+	if sum := i.Summary(); !reflect.DeepEqual(sum, v8.ImportSummary{}) {
+		t.Fatalf("unexpected summary after reset: %#v", sum)
+	}
+	if err := i.ImportFiles([]string{second}); err != nil {
+		t.Fatal(err)
+	} else if sum := i.Summary(); sum.TotalCommands != 1 || sum.TotalInserts != 1 {
+		t.Fatalf("unexpected second summary: %#v", sum)
+	}
+
+	if writes := s.Writes(); len(writes) != 2 {
+		t.Fatalf("unexpected write count: %d", len(writes))
+	} else if writes[1] != "disk,host=server01 value=4 1434055562000000000" {
+		t.Fatalf("unexpected write: %q", writes[1])
+	}
+	if params := s.WriteParams(); params[1].Get("db") != "db1" {
+		t.Fatalf("unexpected write database: %q", params[1].Get("db"))
+	}
+	if q := s.Queries(); len(q) == 0 || q[len(q)-1] != "CREATE DATABASE db1" {
+		t.Fatalf("unexpected queries: %q", q)
+	}
+}
+
+// Ensure that the client is available for queries once an import has connected.
+func TestV8_Client(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	i := v8.NewV8(v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0))
+	if i.Client() != nil {
+		t.Fatal("expected no client before import")
+	}
+	if err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+	c := i.Client()
+	if c == nil {
+		t.Fatal("expected client after import")
+	}
+	if _, err := c.Query(client.Query{Command: "SELECT count(value) FROM cpu", Database: "db0"}); err != nil {
+		t.Fatal(err)
+	}
+	if q := s.Queries(); q[len(q)-1] != "SELECT count(value) FROM cpu" {
+		t.Fatalf("unexpected queries: %q", q)
+	}
+}
+
+// Ensure that lines read ahead are still batched in order, and that a file's
+// last batch doesn't pick up lines from the next file.
+func TestV8_ImportFiles_ReadAhead(t *testing.T) {
+	first := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\ncpu value=1 1\ncpu value=2 2\ncpu value=3 3\n")
+	defer os.Remove(first)
+	second := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\ncpu value=4 4\n")
+	defer os.Remove(second)
+
+	for _, readAhead := range []int{-1, 0, 100} {
+		s := NewServer()
+		config := v8.NewV8Config("", "", "", "", "", "test", s.URL(), false, 2)
+		config.ReadAhead = readAhead
+		if err := v8.NewV8(config).ImportFiles([]string{first, second}); err != nil {
+			t.Fatal(err)
+		}
+		exp := []string{"cpu value=1 1\ncpu value=2 2", "cpu value=3 3", "cpu value=4 4"}
+		if !reflect.DeepEqual(s.Writes(), exp) {
+			t.Fatalf("unexpected writes with read ahead %d:\n\nexp=%#v\n\ngot=%#v", readAhead, exp, s.Writes())
+		}
+		s.Close()
+	}
+}
+
+// Ensure that DDL statements in the DML section are executed in place rather
+// than written as points.
+func TestV8_Import_InterleavedDDL(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(`# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+cpu value=1 1
+CREATE DATABASE db1
+create retention policy rp1 on db1 duration 1h replication 1
+# CONTEXT-DATABASE:db1
+cpu value=2 2
+CREATE value=3 3
+DROP MEASUREMENT cpu
+`)
+	defer os.Remove(path)
+
+	i := v8.NewV8(v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0))
+	if err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"CREATE DATABASE db0", "CREATE DATABASE db1", "create retention policy rp1 on db1 duration 1h replication 1", "DROP MEASUREMENT cpu"}
+	if !reflect.DeepEqual(s.Queries(), exp) {
+		t.Fatalf("unexpected queries:\n\nexp=%#v\n\ngot=%#v", exp, s.Queries())
+	}
+	if exp := []string{"cpu value=1 1", "cpu value=2 2\nCREATE value=3 3"}; !reflect.DeepEqual(s.Writes(), exp) {
+		t.Fatalf("unexpected writes:\n\nexp=%#v\n\ngot=%#v", exp, s.Writes())
+	} else if sum := i.Summary(); sum.TotalCommands != 4 || sum.TotalInserts != 3 {
+		t.Fatalf("unexpected summary: %#v", sum)
+	}
+}
+
+// Ensure that SkipDDL reads past the DDL section, and the statements in the
+// DML section, without executing or writing them.
+func TestV8_Import_SkipDDL(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(`# DDL
+CREATE DATABASE db0
+CREATE RETENTION POLICY rp0 ON db0 DURATION 1h REPLICATION 1
+
+# DML
+# CONTEXT-DATABASE:db0
+cpu value=1 1
+DROP MEASUREMENT cpu
+cpu value=2 2
+`)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.SkipDDL = true
+	i := v8.NewV8(config)
+	if err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+	if q := s.Queries(); len(q) != 0 {
+		t.Fatalf("unexpected queries: %q", q)
+	} else if exp := []string{"cpu value=1 1\ncpu value=2 2"}; !reflect.DeepEqual(s.Writes(), exp) {
+		t.Fatalf("unexpected writes:\n\nexp=%#v\n\ngot=%#v", exp, s.Writes())
+	} else if sum := i.Summary(); sum.TotalCommands != 0 || sum.TotalInserts != 2 {
+		t.Fatalf("unexpected summary: %+v", sum)
+	}
+}
+
+// Ensure that a batch is never written to two databases or retention policies
+// when the context changes partway through it.
+func TestV8_Import_ContextChange(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(`# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:rp0
+cpu value=1 1
+cpu value=2 2
+# CONTEXT-DATABASE:db1
+cpu value=3 3
+# CONTEXT-DATABASE:db1
+cpu value=4 4
+# CONTEXT-RETENTION-POLICY:rp1
+cpu value=5 5
+`)
+	defer os.Remove(path)
+
+	if err := v8.NewV8(v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := []string{"cpu value=1 1\ncpu value=2 2", "cpu value=3 3\ncpu value=4 4", "cpu value=5 5"}; !reflect.DeepEqual(s.Writes(), exp) {
+		t.Fatalf("unexpected writes:\n\nexp=%#v\n\ngot=%#v", exp, s.Writes())
+	}
+	var got []string
+	for _, p := range s.WriteParams() {
+		got = append(got, p.Get("db")+"."+p.Get("rp"))
+	}
+	if exp := []string{"db0.rp0", "db1.rp0", "db1.rp1"}; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected contexts: exp=%q, got=%q", exp, got)
+	}
+}
+
+// Ensure that the lines of interleaved contexts are accumulated into a batch
+// for each, which is written once it is full, unless there is a checkpoint.
+func TestV8_Import_ContextInterleaved(t *testing.T) {
+	path := MustWriteTempFile(`# DML
+# CONTEXT-DATABASE:db0
+cpu value=1 1
+# CONTEXT-DATABASE:db1
+cpu value=2 2
+# CONTEXT-DATABASE:db0
+cpu value=3 3
+# CONTEXT-DATABASE:db1
+cpu value=4 4
+# CONTEXT-DATABASE:db0
+cpu value=5 5
+`)
+	defer os.Remove(path)
+	checkpoint := filepath.Join(os.TempDir(), fmt.Sprintf("influxdb-importer-checkpoint-%d", time.Now().UnixNano()))
+	defer os.Remove(checkpoint)
+
+	for _, tt := range []struct {
+		checkpoint string
+		writes     []string
+		dbs        []string
+	}{
+		{
+			writes: []string{"cpu value=1 1\ncpu value=3 3", "cpu value=2 2\ncpu value=4 4", "cpu value=5 5"},
+			dbs:    []string{"db0", "db1", "db0"},
+		},
+		// A checkpoint needs batches written in the order they were read
+		{
+			checkpoint: checkpoint,
+			writes:     []string{"cpu value=1 1", "cpu value=2 2", "cpu value=3 3", "cpu value=4 4", "cpu value=5 5"},
+			dbs:        []string{"db0", "db1", "db0", "db1", "db0"},
+		},
+	} {
+		s := NewServer()
+		config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 2)
+		config.CheckpointFile = tt.checkpoint
+		if err := v8.NewV8(config).Import(); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(s.Writes(), tt.writes) {
+			t.Fatalf("unexpected writes:\n\nexp=%#v\n\ngot=%#v", tt.writes, s.Writes())
+		}
+		var got []string
+		for _, p := range s.WriteParams() {
+			got = append(got, p.Get("db"))
+		}
+		if !reflect.DeepEqual(got, tt.dbs) {
+			t.Fatalf("unexpected databases: exp=%q, got=%q", tt.dbs, got)
+		}
+		s.Close()
+	}
+}
+
+// Ensure that database and retention policy names containing colons are read in full.
+func TestV8_Import_ContextColon(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(`# DML
+# CONTEXT-DATABASE:metrics:prod
+# CONTEXT-RETENTION-POLICY:30d:raw
+cpu value=1 1
+`)
+	defer os.Remove(path)
+
+	if err := v8.NewV8(v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if p := s.WriteParams(); len(p) != 1 || p[0].Get("db") != "metrics:prod" || p[0].Get("rp") != "30d:raw" {
+		t.Fatalf("unexpected write params: %v", p)
+	}
+}
+
+// Ensure that lines up to MaxLineBytes long are read, and that a longer line
+// fails the import with its line number.
+// Ensure that the summary says how much of the input was left unread when an
+// import stops at a truncated or invalid file.
+func TestV8_Import_Completed(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	b, err := ioutil.ReadFile("testdata/dump.txt.zst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := MustWriteTempFile(string(b[:len(b)/2]))
+	defer os.Remove(truncated)
+	i := v8.NewV8(v8.NewV8Config("", "", "", "", truncated, "test", s.URL(), false, 0))
+	if err := i.Import(); err == nil {
+		t.Fatal("expected error")
+	} else if sum := i.Summary(); sum.Completed || sum.BytesRemaining != -1 {
+		t.Fatalf("unexpected summary for compressed file: %+v", sum)
+	}
+
+	invalid := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\ncpu value=1 1\n" + strings.Repeat("x", 100) + "\n")
+	defer os.Remove(invalid)
+	unread := MustWriteTempFile(dump)
+	defer os.Remove(unread)
+	config := v8.NewV8Config("", "", "", "", "", "test", s.URL(), false, 0)
+	config.MaxLineBytes = 50
+	i = v8.NewV8(config)
+	if err := i.ImportFiles([]string{invalid, unread}); err == nil {
+		t.Fatal("expected error")
+	} else if sum := i.Summary(); sum.Completed || sum.BytesRemaining != int64(len(dump)) {
+		t.Fatalf("unexpected summary: %+v", sum)
+	}
+
+	i = v8.NewV8(v8.NewV8Config("", "", "", "", unread, "test", s.URL(), false, 0))
+	if err := i.Import(); err != nil {
+		t.Fatal(err)
+	} else if sum := i.Summary(); !sum.Completed || sum.BytesRemaining != 0 {
+		t.Fatalf("unexpected summary for complete import: %+v", sum)
+	}
+}
+
+func TestV8_Import_MaxLineBytes(t *testing.T) {
+	long := "cpu,tag=" + strings.Repeat("x", 100) + " value=1 1"
+	for _, tt := range []struct {
+		ending  string
+		tooLong string
+	}{
+		{ending: "\n", tooLong: long + "x"},
+		{ending: "\r\n", tooLong: long + "x"},
+		{ending: "\n", tooLong: long + strings.Repeat("x", 10000)},
+	} {
+		s := NewServer()
+		path := MustWriteTempFile(strings.Join([]string{"# DML", "# CONTEXT-DATABASE:db0", long, "cpu value=2 2", tt.tooLong, ""}, tt.ending))
+
+		config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+		config.MaxLineBytes = len(long)
+		err := v8.NewV8(config).Import()
+		if exp := fmt.Sprintf("line %s:5 is longer than the maximum of %d bytes, MaxLineBytes may need raising", path, len(long)); err == nil || err.Error() != exp {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exp := []string{long + "\ncpu value=2 2"}; !reflect.DeepEqual(s.Writes(), exp) {
+			t.Fatalf("unexpected writes:\n\nexp=%#v\n\ngot=%#v", exp, s.Writes())
+		}
+		os.Remove(path)
+		s.Close()
+	}
+}
+
+// Ensure that a dump with CRLF line endings and blank lines imports cleanly.
+func TestV8_Import_CRLF(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(strings.Replace(dump, "\n", "\r\n", -1) + "  \r\n\t\r\n\r\n")
+	defer os.Remove(path)
+
+	i := v8.NewV8(v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0))
+	if err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"CREATE DATABASE db0", "CREATE RETENTION POLICY rp0 ON db0 DURATION 1h REPLICATION 1"}; !reflect.DeepEqual(s.Queries(), exp) {
+		t.Fatalf("unexpected queries:\n\nexp=%#v\n\ngot=%#v", exp, s.Queries())
+	}
+	exp := "cpu,host=server01 value=1 1434055562000000000\ncpu,host=server02 value=2 1434055562000000000\nmem,host=server01 value=3 1434055562000000000"
+	if writes := s.Writes(); len(writes) != 1 || writes[0] != exp {
+		t.Fatalf("unexpected writes: %q", writes)
+	} else if params := s.WriteParams(); params[0].Get("db") != "db0" || params[0].Get("rp") != "rp0" {
+		t.Fatalf("unexpected write params: %v", params[0])
+	} else if sum := i.Summary(); sum.Blank != 4 || sum.FailedInserts != 0 {
+		t.Fatalf("unexpected summary: %#v", sum)
+	}
+}
+
+// Ensure that a glob pattern imports every matching file in sorted order.
+func TestV8_Import_Glob(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "influxdb-importer-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i, name := range []string{"b.txt", "a.txt", "c.log"} {
+		content := fmt.Sprintf("# DML\n# CONTEXT-DATABASE:db0\ncpu value=%d\n", i)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := v8.NewV8(v8.NewV8Config("", "", "", "", filepath.Join(dir, "*.txt"), "test", s.URL(), false, 0)).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"cpu value=1", "cpu value=0"}; !reflect.DeepEqual(s.Writes(), exp) {
+		t.Fatalf("unexpected writes:\n\nexp=%#v\n\ngot=%#v", exp, s.Writes())
+	}
+}
+
+// Ensure that a glob pattern matching nothing returns an error.
+func TestV8_Import_GlobNoMatch(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	pattern := filepath.Join(os.TempDir(), "influxdb-importer-missing-*.txt")
+	if err := v8.NewV8(v8.NewV8Config("", "", "", "", pattern, "test", s.URL(), false, 0)).Import(); err == nil || err.Error() != fmt.Sprintf("no files match %q", pattern) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that a dry run doesn't send any commands or writes.
+func TestV8_Import_DryRun(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	var l Logger
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.DryRun = true
+	config.Logger = &l
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.Attempts(); n != 0 {
+		t.Fatalf("unexpected write attempts: %d", n)
+	} else if n := len(s.Queries()); n != 0 {
+		t.Fatalf("unexpected queries: %d", n)
+	}
+
+	// A dry run is still timed
+	if msgs := strings.Join(l.Messages(), ""); !strings.Contains(msgs, "Started at ") {
+		t.Fatalf("unexpected messages: %q", msgs)
+	}
+}
+
+// Ensure that a dry run fails a command with trailing tokens, as the server
+// would.
+func TestV8_Import_DryRun_TrailingTokens(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile("# DDL\nCREATE DATABASE db0 garbage\n\n# DML\n# CONTEXT-DATABASE:db0\ncpu value=1 1\n")
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.DryRun = true
+	i := v8.NewV8(config)
+	if err := i.Import(); err == nil || !strings.Contains(err.Error(), "1 commands failed") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sum := i.Summary(); sum.TotalCommands != 1 || sum.FailedCommands != 1 {
+		t.Fatalf("unexpected summary: %+v", sum)
+	} else if n := len(s.Queries()); n != 0 {
+		t.Fatalf("unexpected queries: %d", n)
+	}
+}
+
+// Ensure that the progress callback sees every command and batch.
+func TestV8_Import_Progress(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	// Commands and batches are reported concurrently, so track the highest totals seen.
+	var calls, inserts, commands int
+	var percent float64
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 1)
+	config.Concurrency = 2
+	config.Progress = func(p v8.ProgressReport) {
+		calls++
+		if p.TotalInserts > inserts {
+			inserts = p.TotalInserts
+		}
+		if p.TotalCommands > commands {
+			commands = p.TotalCommands
+		}
+		if p.Percent() > percent {
+			percent = p.Percent()
+		}
+	}
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if inserts != 3 {
+		t.Fatalf("unexpected inserts: %d", inserts)
+	} else if calls != commands+inserts {
+		t.Fatalf("unexpected progress calls: %d", calls)
+	} else if percent != 100 {
+		t.Fatalf("unexpected percent complete: %f", percent)
+	}
+}
+
+// Ensure that progress from standard input reports bytes without a percentage.
+func TestV8_Import_ProgressStdin(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stdin := os.Stdin
+	os.Stdin = f
+	defer func() { os.Stdin = stdin }()
+
+	var last v8.ProgressReport
+	config := v8.NewV8Config("", "", "", "", "-", "test", s.URL(), false, 0)
+	config.Progress = func(p v8.ProgressReport) { last = p }
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if last.BytesRead != int64(len(dump)) {
+		t.Fatalf("unexpected bytes read: %d", last.BytesRead)
+	} else if last.Percent() != -1 {
+		t.Fatalf("unexpected percent complete: %f", last.Percent())
+	}
+}
+
+// Ensure that a target database overrides the CONTEXT-DATABASE header.
+func TestV8_Import_TargetDatabase(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.TargetDatabase = "db0_restore"
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range s.WriteParams() {
+		if db := p.Get("db"); db != "db0_restore" {
+			t.Fatalf("unexpected write database: %q", db)
+		}
+	}
+	for _, p := range s.QueryParams() {
+		if db := p.Get("db"); db != "db0_restore" {
+			t.Fatalf("unexpected query database: %q", db)
+		}
+	}
+}
+
+// Ensure that a target retention policy overrides the CONTEXT-RETENTION-POLICY header.
+func TestV8_Import_TargetRetentionPolicy(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.TargetRetentionPolicy = "longterm"
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	params := s.WriteParams()
+	if len(params) == 0 {
+		t.Fatal("expected at least one write")
+	}
+	for _, p := range params {
+		if rp := p.Get("rp"); rp != "longterm" {
+			t.Fatalf("unexpected write retention policy: %q", rp)
+		} else if db := p.Get("db"); db != "db0" {
+			t.Fatalf("unexpected write database: %q", db)
+		}
+	}
+}
+
+// Ensure that DefaultRetentionPolicy is only written to until a
+// CONTEXT-RETENTION-POLICY header names one.
+func TestV8_Import_DefaultRetentionPolicy(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(`# DML
+# CONTEXT-DATABASE:db0
+cpu value=1 1434055562000000000
+# CONTEXT-RETENTION-POLICY:rp0
+cpu value=2 1434055562000000000
+`)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.DefaultRetentionPolicy = "autogen"
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	var rps []string
+	for _, p := range s.WriteParams() {
+		rps = append(rps, p.Get("rp"))
+	}
+	if exp := []string{"autogen", "rp0"}; !reflect.DeepEqual(rps, exp) {
+		t.Fatalf("unexpected write retention policies: %#v", rps)
+	}
+}
+
+// Ensure that under IdempotentDDL an import can be run again against a server
+// that already has its databases and retention policies, with only other
+// errors counted as failures.
+func TestV8_Import_IdempotentDDL(t *testing.T) {
+	for _, idempotent := range []bool{false, true} {
+		s := NewServer()
+		created := make(map[string]bool)
+		s.QueryError = func(q string) string {
+			if strings.HasPrefix(q, "DROP") {
+				return "database not found"
+			}
+			if created[q] {
+				if strings.HasPrefix(q, "CREATE DATABASE") {
+					return "database already exists"
+				}
+				return "retention policy already exists"
+			}
+			created[q] = true
+			return ""
+		}
+
+		path := MustWriteTempFile(dump + "DROP DATABASE db1\n")
+		config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+		config.IdempotentDDL = idempotent
+		for run := 0; run < 2; run++ {
+			i := v8.NewV8(config)
+			if err := i.Import(); !errors.Is(err, v8.ErrPartialImport) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			exp := 1
+			if run == 1 && !idempotent {
+				exp = 3
+			}
+			if s := i.Summary(); s.TotalCommands != 3 || s.FailedCommands != exp || s.TotalInserts != 3 {
+				t.Fatalf("unexpected summary for run %d, idempotent=%v: %+v", run, idempotent, s)
+			}
+		}
+		os.Remove(path)
+		s.Close()
+	}
+}
+
+// Ensure that the first failed lines are kept in the summary, truncated if
+// they are long.
+func TestV8_Import_SampleFailures(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.RejectLine = func(line string) bool { return strings.HasPrefix(line, "bad") }
+
+	long := "bad,tag=" + strings.Repeat("x", 300) + " value=2"
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\ncpu value=1\nbad value=1\n" + long + "\nbad value=3\n")
+	defer os.Remove(path)
+
+	var l Logger
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 1)
+	config.SampleFailures = 2
+	config.Logger = &l
+	i := v8.NewV8(config)
+	if err := i.Import(); !errors.Is(err, v8.ErrPartialImport) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := []v8.FailureSample{
+		{Location: path + ":4", Line: "bad value=1", Error: "write failed"},
+		{Location: path + ":5", Line: long[:200] + "...", Error: "write failed"},
+	}
+	if got := i.Summary().Failures; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected failures:\n\nexp=%#v\n\ngot=%#v", exp, got)
+	}
+	if msgs := strings.Join(l.Messages(), ""); !strings.Contains(msgs, "Failed line "+path+":4: bad value=1: write failed") {
+		t.Fatalf("expected failed line in messages:\n%s", msgs)
+	}
+}
+
+// Ensure that only lines with included measurements are written.
+func TestV8_Import_IncludeMeasurements(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\n" +
+		"cpu,host=server01 value=1\ncpu_idle value=2\nmem value=3\nmy\\ cpu value=4\ndisk\\,io value=5\n")
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.IncludeMeasurements = []string{"cpu*", "disk,io"}
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"cpu,host=server01 value=1\ncpu_idle value=2\ndisk\\,io value=5"}
+	if !reflect.DeepEqual(s.Writes(), exp) {
+		t.Fatalf("unexpected writes:\n\nexp=%#v\n\ngot=%#v", exp, s.Writes())
+	}
+}
+
+// Ensure that lines with excluded measurements are skipped.
+func TestV8_Import_ExcludeMeasurements(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\n" +
+		"cpu,host=server01 value=1\ncpu_idle value=2\nmem value=3\nmy\\ cpu value=4\n")
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.IncludeMeasurements = []string{"cpu*", "my cpu"}
+	config.ExcludeMeasurements = []string{"cpu_*"}
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"cpu,host=server01 value=1\nmy\\ cpu value=4"}
+	if !reflect.DeepEqual(s.Writes(), exp) {
+		t.Fatalf("unexpected writes:\n\nexp=%#v\n\ngot=%#v", exp, s.Writes())
+	}
+}
+
+// Ensure that SampleRate keeps one in every n lines and the first line of
+// each series, the same lines on every run.
+func TestV8_Import_SampleRate(t *testing.T) {
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\n" +
+		"cpu,host=a value=0\ncpu,host=a value=1\ncpu,host=a value=2\ncpu,host=a value=3\n" +
+		"mem value=4\ncpu,host=b value=5\ncpu,host=a value=6\ncpu,host=a value=7\n")
+	defer os.Remove(path)
+
+	for i := 0; i < 2; i++ {
+		s := NewServer()
+		config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+		config.SampleRate = 3
+		v := v8.NewV8(config)
+		if err := v.Import(); err != nil {
+			t.Fatal(err)
+		}
+		exp := []string{"cpu,host=a value=0\ncpu,host=a value=3\nmem value=4\ncpu,host=b value=5\ncpu,host=a value=6"}
+		if !reflect.DeepEqual(s.Writes(), exp) {
+			t.Fatalf("unexpected writes:\n\nexp=%#v\n\ngot=%#v", exp, s.Writes())
+		} else if n := v.Summary().Skipped; n != 3 {
+			t.Fatalf("unexpected skipped inserts: %d", n)
+		}
+		s.Close()
+	}
+}
+
+// Ensure that measurements are renamed without altering tags or fields.
+func TestV8_Import_MeasurementRename(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\n" +
+		"cpu_old,host=a\\,b value=1\n" +
+		"my\\ cpu,host=a\\ b value=2\n" +
+		"cpu_old value=3 1434055562000000000\n" +
+		"cpu_older,host=a value=4\n" +
+		"mem value=5\n")
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.MeasurementRename = map[string]string{
+		"cpu_old": "cpu",
+		"my cpu":  "cpu, total",
+	}
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"cpu,host=a\\,b value=1\n" +
+		"cpu\\,\\ total,host=a\\ b value=2\n" +
+		"cpu value=3 1434055562000000000\n" +
+		"cpu_older,host=a value=4\n" +
+		"mem value=5"}
+	if !reflect.DeepEqual(s.Writes(), exp) {
+		t.Fatalf("unexpected writes:\n\nexp=%#v\n\ngot=%#v", exp, s.Writes())
+	}
+}
+
+// Ensure that fields with a type hint are rewritten to be parsed as that
+// type, and that a line with a field that can't be is rejected.
+func TestV8_Import_FieldTypeHints(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(`# DML
+# CONTEXT-DATABASE:db0
+cpu,host=a\ b count=2.0,load=2,name=web,up=t,other=3 1
+cpu,host=a count=7,load=0.5,name="a b",up=1 2
+cpu,host=a count=2.5 3
+mem count=2.0,load=2
+`)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.FieldTypeHints = map[string]string{
+		"cpu.count": "integer",
+		"cpu.load":  "float",
+		"cpu.name":  "string",
+		"cpu.up":    "boolean",
+	}
+	i := v8.NewV8(config)
+	if err := i.Import(); !errors.Is(err, v8.ErrPartialImport) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if s := i.Summary(); s.TotalInserts != 3 || s.Rejected != 1 {
+		t.Fatalf("unexpected summary: %+v", s)
+	}
+
+	exp := `cpu,host=a\ b count=2,load=2.0,name="web",up=true,other=3 1
+cpu,host=a count=7,load=0.5,name="a b",up=true 2
+mem count=2.0,load=2`
+	if writes := s.Writes(); len(writes) != 1 || writes[0] != exp {
+		t.Fatalf("unexpected writes: %q", writes)
+	}
+	for _, line := range strings.Split(exp, "\n") {
+		if _, err := tsdb.ParsePoints([]byte(line)); err != nil {
+			t.Fatalf("unexpected error parsing %q: %v", line, err)
+		}
+	}
+}
+
+// Ensure that a line rejected by a type hint is saved as it was when it
+// failed, after FixEscaping, like lines rejected by ValidateLines.
+func TestV8_Import_FieldTypeHints_FailedLinesFile(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\ncpu,host=a b count=2.5 1\n")
+	defer os.Remove(path)
+	failed := MustWriteTempFile("")
+	defer os.Remove(failed)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.FieldTypeHints = map[string]string{"cpu.count": "integer"}
+	config.FixEscaping = true
+	config.FailedLinesFile = failed
+	config.Quiet = true
+	if err := v8.NewV8(config).Import(); !errors.Is(err, v8.ErrPartialImport) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "# DML\n# CONTEXT-DATABASE:db0\n# " + path + ":3-3\ncpu,host=a\\ b count=2.5 1\n"; string(b) != exp {
+		t.Fatalf("unexpected failed lines:\n\nexp=%s\n\ngot=%s", exp, b)
+	}
+}
+
+// Ensure that an unknown field type hint is rejected before anything is written.
+func TestV8_Import_FieldTypeHints_Unknown(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	config := v8.NewV8Config("", "", "", "", "dump.txt", "test", s.URL(), false, 0)
+	config.FieldTypeHints = map[string]string{"cpu.count": "int64"}
+	if err := v8.NewV8(config).Import(); err == nil || err.Error() != `unknown type "int64" for field cpu.count, expected one of integer, float, string or boolean` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that writes are limited to PointsPerSecond across all writers.
+func TestV8_Import_PointsPerSecond(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 1)
+	config.Concurrency = 3
+	config.PointsPerSecond = 10
+	start := time.Now()
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	// The first point is written immediately and each of the others waits 100ms.
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Fatalf("import finished too quickly: %s", d)
+	} else if n := len(s.Writes()); n != 3 {
+		t.Fatalf("unexpected write count: %d", n)
+	}
+}
+
+// Ensure that an import with a cancelled context writes nothing.
+func TestV8_ImportContext_Cancelled(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := v8.NewV8(v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)).ImportContext(ctx); err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	} else if n := len(s.Writes()); n != 0 {
+		t.Fatalf("unexpected write count: %d", n)
+	}
+}
+
+// Ensure that cancelling an import stops it before the file has been read.
+func TestV8_ImportContext_CancelDuringImport(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	var buf bytes.Buffer
+	buf.WriteString("# DML\n# CONTEXT-DATABASE:db0\n")
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&buf, "cpu value=%d\n", i)
+	}
+	path := MustWriteTempFile(buf.String())
+	defer os.Remove(path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 1)
+	config.Progress = func(v8.ProgressReport) { cancel() }
+	if err := v8.NewV8(config).ImportContext(ctx); err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	} else if n := len(s.Writes()); n == 0 || n >= 10000 {
+		t.Fatalf("unexpected write count: %d", n)
+	}
+}
+
+// Ensure that an interrupt stops the import with ErrInterrupted once the
+// lines already read are written, when StopOnInterrupt is set.
+func TestV8_Import_StopOnInterrupt(t *testing.T) {
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Skip(err)
+	}
+
+	s := NewServer()
+	defer s.Close()
+
+	var buf bytes.Buffer
+	buf.WriteString("# DML\n# CONTEXT-DATABASE:db0\n")
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&buf, "cpu value=%d\n", i)
+	}
+	path := MustWriteTempFile(buf.String())
+	defer os.Remove(path)
+
+	var once sync.Once
+	var logger Logger
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 10)
+	config.StopOnInterrupt = true
+	config.Logger = &logger
+	config.Progress = func(v8.ProgressReport) {
+		once.Do(func() {
+			if err := p.Signal(os.Interrupt); err != nil {
+				t.Errorf("unexpected error sending interrupt: %v", err)
+			}
+		})
+	}
+	i := v8.NewV8(config)
+	if err := i.Import(); !errors.Is(err, v8.ErrInterrupted) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	n := 0
+	for _, w := range s.Writes() {
+		n += len(strings.Split(w, "\n"))
+	}
+	if n == 0 || n >= 10000 {
+		t.Fatalf("unexpected line count: %d", n)
+	} else if s := i.Summary(); s.TotalInserts != n || s.FailedInserts != 0 {
+		t.Fatalf("unexpected summary: %+v", s)
+	}
+	if m := strings.Join(logger.Messages(), ""); !strings.Contains(m, "Received interrupt") || !strings.Contains(m, "Processed ") {
+		t.Fatalf("unexpected messages: %q", m)
+	}
+}
+
+// Ensure that the summary reports the totals of the import.
+func TestV8_Summary(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	content := "# DML\n# CONTEXT-DATABASE:db0\ncpu value=1\ncpu value=2\nmem value=3\n"
+	path := MustWriteTempFile(content)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.ExcludeMeasurements = []string{"mem"}
+	i := v8.NewV8(config)
+	if err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+	sum := i.Summary()
+	if sum.Duration <= 0 {
+		t.Fatalf("unexpected duration: %s", sum.Duration)
+	} else if sum.StartedAt.IsZero() || sum.FinishedAt.Sub(sum.StartedAt) != sum.Duration {
+		t.Fatalf("unexpected start and finish: %s, %s", sum.StartedAt, sum.FinishedAt)
+	}
+	if exp := float64(len("cpu value=1\ncpu value=2")) / 1e6 / sum.Duration.Seconds(); sum.Throughput != exp {
+		t.Fatalf("unexpected throughput: %f, expected %f", sum.Throughput, exp)
+	}
+	sum.StartedAt, sum.FinishedAt, sum.Duration, sum.Throughput = time.Time{}, time.Time{}, 0, 0
+	exp := v8.ImportSummary{TotalInserts: 2, Skipped: 1, Measurements: map[string]int{"cpu": 2}, BytesRead: int64(len(content)),
+		Completed: true, BytesWritten: int64(len("cpu value=1\ncpu value=2"))}
+	if !reflect.DeepEqual(sum, exp) {
+		t.Fatalf("unexpected summary:\n\nexp=%#v\n\ngot=%#v", exp, sum)
+	}
+}
+
+// Ensure that the summary is printed as JSON on standard output when requested.
+func TestV8_Import_JSONSummary(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\ncpu value=1\n")
+	defer os.Remove(path)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.JSONSummary = true
+	err = v8.NewV8(config).Import()
+	w.Close()
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sum v8.ImportSummary
+	if err := json.NewDecoder(r).Decode(&sum); err != nil {
+		t.Fatal(err)
+	} else if sum.TotalInserts != 1 {
+		t.Fatalf("unexpected total inserts: %d", sum.TotalInserts)
+	}
+}
+
+// Ensure that log messages are written to the configured logger.
+func TestV8_Import_Logger(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\ncpu value=1\n")
+	defer os.Remove(path)
+
+	var l Logger
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.Logger = &l
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if msgs := l.Messages(); len(msgs) < 2 || msgs[1] != "Processed 1 inserts\n" {
+		t.Fatalf("unexpected messages: %#v", msgs)
+	}
+}
+
+// Ensure that quiet mode suppresses errors for each batch but keeps the summary.
+func TestV8_Import_Quiet(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteStatus = func(n int) int { return http.StatusBadRequest }
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\ncpu value=1\ncpu value=2\n")
+	defer os.Remove(path)
+
+	var l Logger
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 1)
+	config.Logger = &l
+	config.Quiet = true
+	if err := v8.NewV8(config).Import(); !errors.Is(err, v8.ErrPartialImport) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := []string{"Processed 0 commands\n", "Processed 0 inserts\n", "Failed 2 inserts\n", "Failed 2 batches\n"}
+	msgs := l.Messages()
+	if len(msgs) != len(exp)+1 || !reflect.DeepEqual(msgs[:len(exp)], exp) || !strings.HasPrefix(msgs[len(exp)], "Started at ") {
+		t.Fatalf("unexpected messages:\n\nexp=%#v\n\ngot=%#v", exp, msgs)
+	}
+}
+
+// Ensure that verbose mode logs every batch written.
+func TestV8_Import_Verbose(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\n# CONTEXT-RETENTION-POLICY:rp0\ncpu value=1\ncpu value=2\n")
+	defer os.Remove(path)
+
+	var l Logger
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 1)
+	config.Logger = &l
+	config.Verbose = true
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	if msgs := l.Messages(); len(msgs) < 2 || msgs[0] != "wrote 1 lines to db0.rp0\n" || msgs[1] != msgs[0] {
+		t.Fatalf("unexpected messages: %#v", msgs)
+	}
+}
+
+// Ensure that failed DDL commands are counted.
+func TestV8_Import_FailedCommands(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.QueryError = func(q string) string {
+		if strings.HasPrefix(q, "CREATE RETENTION POLICY") {
+			return "database not found"
+		}
+		return ""
+	}
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	i := v8.NewV8(v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0))
+	if err := i.Import(); !errors.Is(err, v8.ErrPartialImport) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := i.Summary().FailedCommands; n != 1 {
+		t.Fatalf("unexpected failed commands: %d", n)
+	} else if n := len(s.Writes()); n != 1 {
+		t.Fatalf("unexpected write count: %d", n)
+	}
+}
+
+// Ensure that a failed DDL command stops a strict import before any writes.
+func TestV8_Import_StrictDDL(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.QueryError = func(q string) string {
+		if strings.HasPrefix(q, "CREATE RETENTION POLICY") {
+			return "database not found"
+		}
+		return ""
+	}
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.StrictDDL = true
+	err := v8.NewV8(config).Import()
+	if err == nil || !strings.Contains(err.Error(), "database not found") {
+		t.Fatalf("unexpected error: %v", err)
+	} else if n := len(s.Writes()); n != 0 {
+		t.Fatalf("unexpected write count: %d", n)
+	}
+}
+
+// Ensure that an import is aborted once too many inserts have failed.
+func TestV8_Import_MaxFailedInserts(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteStatus = func(n int) int { return http.StatusBadRequest }
+
+	var buf bytes.Buffer
+	buf.WriteString("# DML\n# CONTEXT-DATABASE:db0\n")
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&buf, "cpu value=%d\n", i)
+	}
+	path := MustWriteTempFile(buf.String())
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 1)
+	config.MaxFailedInserts = 5
+	config.Quiet = true
+	i := v8.NewV8(config)
+	if err := i.Import(); err == nil || !strings.HasPrefix(err.Error(), "aborted after 6 failed inserts") {
+		t.Fatalf("unexpected error: %v", err)
+	} else if n := i.Summary().FailedInserts; n < 6 || n >= 10000 {
+		t.Fatalf("unexpected failed inserts: %d", n)
+	}
+}
+
+// Ensure that StopOnWriteError aborts the import at the first failed batch.
+func TestV8_Import_StopOnWriteError(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteStatus = func(n int) int {
+		if n == 2 {
+			return http.StatusBadRequest
+		}
+		return http.StatusNoContent
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# DML\n# CONTEXT-DATABASE:db0\n")
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&buf, "cpu value=%d\n", i)
+	}
+	path := MustWriteTempFile(buf.String())
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 1)
+	config.StopOnWriteError = true
+	config.Quiet = true
+	i := v8.NewV8(config)
+	if err := i.Import(); err == nil || !strings.HasPrefix(err.Error(), "aborted after batch "+path+":5-5 failed") {
+		t.Fatalf("unexpected error: %v", err)
+	} else if sum := i.Summary(); sum.FailedInserts != 1 || sum.TotalInserts >= 9999 {
+		t.Fatalf("unexpected summary: %+v", sum)
+	}
+}
+
+// Ensure that each category of failure can be told apart with errors.As.
+func TestV8_Import_ErrorTypes(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	// The server can't be reached. It may still be answering a retried
+	// ping when the import returns, so it isn't reused.
+	unreachable := NewServer()
+	defer unreachable.Close()
+	unreachable.Drop = func(path string) bool { return path == "/ping" }
+	var ce *v8.ConnectError
+	if err := v8.NewV8(v8.NewV8Config("", "", "", "", path, "test", unreachable.URL(), false, 0)).Import(); !errors.As(err, &ce) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if ce.Addr == "" || ce.Replica || ce.Err == nil || ce.Error() != "failed to connect to "+ce.Addr {
+		t.Fatalf("unexpected connect error: %+v", ce)
+	}
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+
+	// A DDL command fails
+	s.QueryError = func(q string) string {
+		if strings.HasPrefix(q, "CREATE RETENTION POLICY") {
+			return "database not found"
+		}
+		return ""
+	}
+	config.StrictDDL = true
+	var de *v8.DDLError
+	if err := v8.NewV8(config).Import(); !errors.As(err, &de) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !strings.HasPrefix(de.Command, "CREATE RETENTION POLICY") || !strings.HasPrefix(de.Location, path+":") {
+		t.Fatalf("unexpected DDL error: %+v", de)
+	} else if !strings.HasPrefix(err.Error(), "DDL command failed: "+de.Location+": ") {
+		t.Fatalf("unexpected message: %s", err)
+	}
+	s.QueryError = nil
+	config.StrictDDL = false
+
+	// A batch fails
+	s.WriteStatus = func(n int) int { return http.StatusBadRequest }
+	config.StopOnWriteError = true
+	config.Quiet = true
+	var we *v8.WriteError
+	if err := v8.NewV8(config).Import(); !errors.As(err, &we) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if we.Batch == "" || we.Err == nil || we.FailedInserts == 0 {
+		t.Fatalf("unexpected write error: %+v", we)
+	}
+}
+
+// Ensure that an import is not aborted while failures stay within the limit.
+func TestV8_Import_MaxFailedInserts_NotExceeded(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteStatus = func(n int) int {
+		if n == 0 {
+			return http.StatusBadRequest
+		}
+		return http.StatusNoContent
+	}
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 1)
+	config.MaxFailedInserts = 1
+	config.Quiet = true
+	if err := v8.NewV8(config).Import(); !errors.Is(err, v8.ErrPartialImport) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if n := len(s.Writes()); n != 2 {
+		t.Fatalf("unexpected write count: %d", n)
+	}
+}
+
+// Ensure that errors say which lines of the file they came from.
+func TestV8_Import_ErrorLineNumbers(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteStatus = func(n int) int { return http.StatusBadRequest }
+	s.QueryError = func(q string) string {
+		if strings.HasPrefix(q, "CREATE RETENTION POLICY") {
+			return "database not found"
+		}
+		return ""
+	}
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	var l Logger
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 2)
+	config.Logger = &l
+	if err := v8.NewV8(config).Import(); !errors.Is(err, v8.ErrPartialImport) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msgs := strings.Join(l.Messages(), "")
+	for _, exp := range []string{
+		"error: " + path + ":3: database not found\n",
+		"error writing batch " + path + ":8-9: write failed\n",
+		"error writing batch " + path + ":10-10: write failed\n",
+	} {
+		if !strings.Contains(msgs, exp) {
+			t.Fatalf("expected %q in messages:\n%s", exp, msgs)
+		}
+	}
+}
+
+// Ensure that invalid lines are rejected without failing the rest of their batch.
+func TestV8_Import_ValidateLines(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\n" +
+		"cpu value=1 1434055562\n" +
+		"cpu\n" +
+		"cpu value=2 notatime\n" +
+		"cpu value=3 1434055563\n")
+	defer os.Remove(path)
+	failed := MustWriteTempFile("")
+	defer os.Remove(failed)
+
+	config := v8.NewV8Config("", "", "s", "", path, "test", s.URL(), false, 0)
+	config.ValidateLines = true
+	config.FailedLinesFile = failed
+	config.Quiet = true
+	i := v8.NewV8(config)
+	if err := i.Import(); !errors.Is(err, v8.ErrPartialImport) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if exp := []string{"cpu value=1 1434055562\ncpu value=3 1434055563"}; !reflect.DeepEqual(s.Writes(), exp) {
+		t.Fatalf("unexpected writes:\n\nexp=%#v\n\ngot=%#v", exp, s.Writes())
+	} else if sum := i.Summary(); sum.Rejected != 2 || sum.TotalInserts != 2 {
+		t.Fatalf("unexpected summary: %#v", sum)
+	}
+
+	b, err := ioutil.ReadFile(failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "# DML\n# CONTEXT-DATABASE:db0\n" +
+		"# " + path + ":4-4\ncpu\n" +
+		"# " + path + ":5-5\ncpu value=2 notatime\n"
+	if string(b) != exp {
+		t.Fatalf("unexpected failed lines:\n\nexp=%s\n\ngot=%s", exp, b)
+	}
+}
+
+// Ensure that lines left out by the filters are skipped rather than rejected
+// under ValidateLines, however they are escaped.
+func TestV8_Import_ValidateLines_Excluded(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\n" +
+		"old,host=a b value=1 1434055562000000000\n" +
+		"cpu value=2 1434055562000000000\n")
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.ValidateLines = true
+	config.ExcludeMeasurements = []string{"old"}
+	i := v8.NewV8(config)
+	if err := i.Import(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if sum := i.Summary(); sum.Rejected != 0 || sum.Skipped != 1 || sum.TotalInserts != 1 {
+		t.Fatalf("unexpected summary: %#v", sum)
+	}
+}
+
+// Ensure that FixEscaping escapes the spaces, commas and equals signs left
+// unescaped in series keys and field keys, and that ValidateLines rejects them.
+func TestV8_Import_FixEscaping(t *testing.T) {
+	lines := []struct{ line, fixed string }{
+		{line: `cpu,host=my server value=1 1`, fixed: `cpu,host=my\ server value=1 1`},
+		{line: `cpu,host=a,b,region=west value=1 2`, fixed: `cpu,host=a\,b,region=west value=1 2`},
+		{line: `cpu,host=a=b value=1 3`, fixed: `cpu,host=a\=b value=1 3`},
+		{line: `cpu,host=a used,percent=5 4`, fixed: `cpu,host=a used\,percent=5 4`},
+		{line: `cpu,host=a msg="x, y z" 5`, fixed: `cpu,host=a msg="x, y z" 5`},
+		{line: `my\ cpu,host=a\ b value=1 6`, fixed: `my\ cpu,host=a\ b value=1 6`},
+	}
+	var content string
+	for _, l := range lines {
+		content += l.line + "\n"
+	}
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\n" + content)
+	defer os.Remove(path)
+
+	s := NewServer()
+	defer s.Close()
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.FixEscaping = true
+	i := v8.NewV8(config)
+	if err := i.Import(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if sum := i.Summary(); sum.Fixed != 4 || sum.TotalInserts != 6 {
+		t.Fatalf("unexpected summary: %+v", sum)
+	}
+	var exp []string
+	for _, l := range lines {
+		exp = append(exp, l.fixed)
+		if _, err := tsdb.ParsePointsString(l.fixed); err != nil {
+			t.Fatalf("unexpected error parsing %q: %v", l.fixed, err)
+		}
+	}
+	if writes := s.Writes(); len(writes) != 1 || writes[0] != strings.Join(exp, "\n") {
+		t.Fatalf("unexpected writes: %q", writes)
+	}
+
+	s2 := NewServer()
+	defer s2.Close()
+	config = v8.NewV8Config("", "", "", "", path, "test", s2.URL(), false, 0)
+	config.ValidateLines = true
+	config.Quiet = true
+	i = v8.NewV8(config)
+	if err := i.Import(); !errors.Is(err, v8.ErrPartialImport) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if sum := i.Summary(); sum.Rejected != 4 || sum.TotalInserts != 2 {
+		t.Fatalf("unexpected summary: %+v", sum)
+	}
+}
+
+// Ensure that a rejected batch is split until only the bad lines fail.
+func TestV8_Import_BisectOnFailure(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.RejectLine = func(line string) bool { return strings.Contains(line, "bad") }
+
+	var buf bytes.Buffer
+	buf.WriteString("# DML\n# CONTEXT-DATABASE:db0\n")
+	for i := 0; i < 10; i++ {
+		if i == 2 || i == 7 {
+			fmt.Fprintf(&buf, "bad value=%d\n", i)
+		} else {
+			fmt.Fprintf(&buf, "cpu value=%d\n", i)
+		}
+	}
+	path := MustWriteTempFile(buf.String())
+	defer os.Remove(path)
+	failed := MustWriteTempFile("")
+	defer os.Remove(failed)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.BisectOnFailure = true
+	config.FailedLinesFile = failed
+	config.Quiet = true
+	i := v8.NewV8(config)
+	if err := i.Import(); !errors.Is(err, v8.ErrPartialImport) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var written []string
+	for _, w := range s.Writes() {
+		written = append(written, strings.Split(w, "\n")...)
+	}
+	if len(written) != 8 {
+		t.Fatalf("unexpected lines written: %#v", written)
+	}
+	for _, l := range written {
+		if strings.Contains(l, "bad") {
+			t.Fatalf("unexpected line written: %q", l)
+		}
+	}
+	if sum := i.Summary(); sum.TotalInserts != 8 || sum.FailedInserts != 2 {
+		t.Fatalf("unexpected summary: %#v", sum)
+	}
+
+	b, err := ioutil.ReadFile(failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "# DML\n# CONTEXT-DATABASE:db0\n" +
+		"# " + path + ":5-5\nbad value=2\n" +
+		"# " + path + ":10-10\nbad value=7\n"
+	if string(b) != exp {
+		t.Fatalf("unexpected failed lines:\n\nexp=%s\n\ngot=%s", exp, b)
+	}
+}
+
+// Ensure that an export can be streamed from a URL.
+func TestV8_Import_URL(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(dump))
+	gz.Close()
+	src := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sig") != "abc" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Write(buf.Bytes())
+	}))
+	defer src.Close()
+
+	if err := v8.NewV8(v8.NewV8Config("", "", "", "", src.URL+"/dump.gz?sig=abc", "test", s.URL(), false, 0)).Import(); err != nil {
+		t.Fatal(err)
+	} else if writes := s.Writes(); len(writes) != 1 || strings.Count(writes[0], "\n") != 2 {
+		t.Fatalf("unexpected writes: %#v", writes)
+	}
+
+	// The signature of a URL that can't be fetched is left out of the error.
+	err := v8.NewV8(v8.NewV8Config("", "", "", "", src.URL+"/dump.gz?sig=xyz", "test", s.URL(), false, 0)).Import()
+	if exp := "fetching " + src.URL + "/dump.gz: 403 Forbidden"; err == nil || err.Error() != exp {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that ResumeDownloads continues a download that drops with a range
+// request, and that without it the import fails.
+func TestV8_Import_URL_ResumeDownloads(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("# DML\n# CONTEXT-DATABASE:db0\n")
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&buf, "cpu value=%d %d\n", i, i)
+	}
+	content := buf.Bytes()
+	modified := time.Unix(1434055562, 0)
+	src := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			http.ServeContent(w, r, "dump", modified, bytes.NewReader(content))
+			return
+		}
+		// Send half of the file, then drop the connection
+		w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		w.Write(content[:len(content)/2])
+		w.(http.Flusher).Flush()
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer src.Close()
+
+	s := NewServer()
+	defer s.Close()
+	logger := &Logger{}
+	config := v8.NewV8Config("", "", "", "", src.URL+"/dump", "test", s.URL(), false, 0)
+	config.ResumeDownloads = 1
+	config.Logger = logger
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if writes := s.Writes(); len(writes) != 1 || strings.Count(writes[0], "\n") != 999 {
+		t.Fatalf("unexpected writes: %d", len(writes))
+	} else if msgs := strings.Join(logger.Messages(), ""); !strings.Contains(msgs, "Download of "+src.URL+"/dump dropped after") {
+		t.Fatalf("unexpected messages: %q", msgs)
+	}
+
+	config = v8.NewV8Config("", "", "", "", src.URL+"/dump", "test", s.URL(), false, 0)
+	config.Logger = &Logger{}
+	if err := v8.NewV8(config).Import(); err == nil || !strings.Contains(err.Error(), "unexpected EOF") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that ResumeDownloads resumes a download stored gzip-encoded from the
+// right place, asking for it as it is stored rather than decompressed.
+func TestV8_Import_URL_ResumeDownloadsEncoded(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("# DML\n# CONTEXT-DATABASE:db0\n")
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&buf, "cpu value=%d %d\n", i, i)
+	}
+	content := []byte(MustGzip(buf.String()))
+	modified := time.Unix(1434055562, 0)
+	var mu sync.Mutex
+	var encodings []string
+	src := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		encodings = append(encodings, r.Header.Get("Accept-Encoding"))
+		mu.Unlock()
+
+		// Like an object store, always send the object as stored, with
+		// ranges of its encoded bytes
+		w.Header().Set("Content-Encoding", "gzip")
+		if r.Header.Get("Range") != "" {
+			http.ServeContent(w, r, "dump", modified, bytes.NewReader(content))
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		w.Write(content[:len(content)/2])
+		w.(http.Flusher).Flush()
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer src.Close()
+
+	s := NewServer()
+	defer s.Close()
+	config := v8.NewV8Config("", "", "", "", src.URL+"/dump", "test", s.URL(), false, 0)
+	config.ResumeDownloads = 1
+	config.Logger = &Logger{}
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if writes := s.Writes(); len(writes) != 1 || strings.Count(writes[0], "\n") != 999 {
+		t.Fatalf("unexpected writes: %d", len(writes))
+	} else if !reflect.DeepEqual(encodings, []string{"identity", "identity"}) {
+		t.Fatalf("unexpected Accept-Encoding headers: %q", encodings)
+	}
+}
+
+// Ensure that a server with a self-signed certificate can be used with UnsafeSsl.
+func TestV8_Import_UnsafeSsl(t *testing.T) {
+	s := NewTLSServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	if err := v8.NewV8(v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)).Import(); err == nil {
+		t.Fatal("expected an error verifying the self-signed certificate")
+	}
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.UnsafeSsl = true
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	} else if n := len(s.Writes()); n != 1 {
+		t.Fatalf("unexpected write count: %d", n)
+	}
+}
+
+// Ensure that requests are sent through the configured Proxy.
+func TestV8_Import_Proxy(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	// The server answers on behalf of a host that doesn't resolve, so the
+	// import only succeeds if it goes through the proxy.
+	u := s.URL()
+	config := v8.NewV8Config("", "", "", "", path, "test", url.URL{Scheme: "http", Host: "influxdb.example:8086"}, false, 0)
+	config.Proxy = u.String()
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	} else if n := len(s.Writes()); n != 1 {
+		t.Fatalf("unexpected write count: %d", n)
+	}
+
+	// A malformed proxy is rejected before anything is read.
+	config.Proxy = "proxy:3128"
+	if err := v8.NewV8(config).Import(); err == nil || !strings.Contains(err.Error(), "invalid proxy") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that an auth token is sent with every request.
+func TestV8_Import_AuthToken(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AuthToken = "secret"
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.AuthToken = "secret"
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	} else if n := len(s.Writes()); n != 1 {
+		t.Fatalf("unexpected write count: %d", n)
+	} else if n := len(s.Queries()); n != 2 {
+		t.Fatalf("unexpected query count: %d", n)
+	}
+
+	// A token can't be combined with a username.
+	config = v8.NewV8Config("user", "", "", "", path, "test", s.URL(), false, 0)
+	config.AuthToken = "secret"
+	if err := v8.NewV8(config).Import(); err == nil || !strings.Contains(err.Error(), "auth token") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that custom headers are sent with every request.
+func TestV8_Import_Headers(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.RequiredHeaders = map[string]string{"X-Tenant-ID": "t0"}
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.Headers = map[string]string{"X-Tenant-ID": "t0"}
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	} else if n := len(s.Writes()); n != 1 {
+		t.Fatalf("unexpected write count: %d", n)
+	}
+}
+
+// Ensure that the password can be read from a file or an environment variable.
+func TestV8_Import_PasswordFile(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.RequiredHeaders = map[string]string{"Authorization": "Basic YWRtaW46c2VjcmV0"} // admin:secret
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+	passwordFile := MustWriteTempFile("secret\n")
+	defer os.Remove(passwordFile)
+	t.Setenv("INFLUX_TEST_PASSWORD", "secret")
+
+	for _, set := range []func(*v8.V8Config){
+		func(c *v8.V8Config) { c.PasswordFile = passwordFile },
+		func(c *v8.V8Config) { c.PasswordEnv = "INFLUX_TEST_PASSWORD" },
+	} {
+		config := v8.NewV8Config("admin", "", "", "", path, "test", s.URL(), false, 0)
+		set(config)
+		if err := v8.NewV8(config).Import(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(s.Writes()); n != 2 {
+		t.Fatalf("unexpected write count: %d", n)
+	}
+
+	config := v8.NewV8Config("admin", "secret", "", "", path, "test", s.URL(), false, 0)
+	config.PasswordFile = passwordFile
+	if err := v8.NewV8(config).Import(); err == nil || !strings.Contains(err.Error(), "only one of") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	config = v8.NewV8Config("admin", "", "", "", path, "test", s.URL(), false, 0)
+	config.PasswordEnv = "INFLUX_TEST_PASSWORD_UNSET"
+	if err := v8.NewV8(config).Import(); err == nil || !strings.Contains(err.Error(), "INFLUX_TEST_PASSWORD_UNSET is not set") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that SkipPing imports through a server whose /ping is blocked.
+func TestV8_Import_SkipPing(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Drop = func(path string) bool { return path == "/ping" }
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	if err := v8.NewV8(config).Import(); err == nil || !strings.Contains(err.Error(), "failed to connect") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config.SkipPing = true
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if n := len(s.Writes()); n != 1 {
+		t.Fatalf("unexpected write count: %d", n)
+	}
+}
+
+// Ensure that a warning is logged when an export is from another release
+// than the server it is imported into.
+func TestV8_Import_VersionMismatch(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Version = "0.9.4"
+
+	for _, tt := range []struct {
+		version string
+		warn    bool
+	}{
+		{"0.9.1", false},
+		{"v0.9", false},
+		{"0.8.8", true},
+	} {
+		path := MustWriteTempFile("# INFLUXDB-VERSION:" + tt.version + "\n" + dump)
+		defer os.Remove(path)
+
+		var logger Logger
+		config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+		config.Logger = &logger
+		if err := v8.NewV8(config).Import(); err != nil {
+			t.Fatal(err)
+		}
+		m := strings.Join(logger.Messages(), "")
+		if warned := strings.Contains(m, "was exported from InfluxDB "+tt.version+" but is being imported into 0.9.4"); warned != tt.warn {
+			t.Fatalf("unexpected messages for version %s: %q", tt.version, m)
+		}
+	}
+
+	// Exports start with the version of the server they were made from
+	c, err := client.NewClient(client.Config{URL: s.URL()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := v8.NewExporter(c, v8.ExportConfig{Database: "db0"}).Export(&buf); err != nil {
+		t.Fatal(err)
+	} else if !strings.HasPrefix(buf.String(), "# INFLUXDB-VERSION:0.9.4\n# DDL\n") {
+		t.Fatalf("unexpected export: %q", buf.String())
+	}
+}
+
+// Ensure that a write that takes longer than the timeout is reported as such.
+func TestV8_Import_Timeout(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteDelay = 300 * time.Millisecond
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	var l Logger
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.Timeout = 50 * time.Millisecond
+	config.Logger = &l
+	if err := v8.NewV8(config).Import(); !errors.Is(err, v8.ErrPartialImport) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msgs := strings.Join(l.Messages(), ""); !strings.Contains(msgs, "request timed out after 50ms") {
+		t.Fatalf("expected a timeout in messages:\n%s", msgs)
+	}
+}
+
+// Ensure that commands and writes are given their own timeouts.
+func TestV8_Import_WriteTimeout_QueryTimeout(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteDelay = 100 * time.Millisecond
+	s.QueryError = func(q string) string {
+		time.Sleep(100 * time.Millisecond)
+		return ""
+	}
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	var l Logger
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.Timeout = 20 * time.Millisecond
+	config.QueryTimeout = time.Second
+	config.WriteTimeout = 30 * time.Millisecond
+	config.Logger = &l
+	i := v8.NewV8(config)
+	if err := i.Import(); !errors.Is(err, v8.ErrPartialImport) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s := i.Summary(); s.FailedCommands != 0 || s.FailedInserts != 3 {
+		t.Fatalf("unexpected summary: %+v", s)
+	}
+	if msgs := strings.Join(l.Messages(), ""); !strings.Contains(msgs, "request timed out after 30ms, the WriteTimeout may need raising") {
+		t.Fatalf("expected a write timeout in messages:\n%s", msgs)
+	}
+}
+
+// Ensure that compressed writes are decoded to the original lines.
+func TestV8_Import_CompressWrites(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.CompressWrites = true
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"cpu,host=server01 value=1 1434055562000000000\n" +
+		"cpu,host=server02 value=2 1434055562000000000\n" +
+		"mem,host=server01 value=3 1434055562000000000"}
+	if !reflect.DeepEqual(s.Writes(), exp) {
+		t.Fatalf("unexpected writes:\n\nexp=%#v\n\ngot=%#v", exp, s.Writes())
+	}
+}
+
+// Ensure that an interrupted import resumes from its checkpoint without
+// writing any line twice or skipping any.
+func TestV8_Import_Checkpoint(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("# DDL\nCREATE DATABASE db0\n\n# DML\n# CONTEXT-DATABASE:db0\n")
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&buf, "cpu value=%d\n", i)
+	}
+	path := MustWriteTempFile(buf.String())
+	defer os.Remove(path)
+	checkpoint := filepath.Join(os.TempDir(), fmt.Sprintf("influxdb-importer-checkpoint-%d", time.Now().UnixNano()))
+	defer os.Remove(checkpoint)
+
+	// Stop the first import part way through.
+	s0 := NewServer()
+	defer s0.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := v8.NewV8Config("", "", "", "", path, "test", s0.URL(), false, 10)
+	config.Concurrency = 3
+	config.CheckpointFile = checkpoint
+	config.CheckpointInterval = 2
+	config.Progress = func(p v8.ProgressReport) {
+		if p.TotalInserts >= 300 {
+			cancel()
+		}
+	}
+	if err := v8.NewV8(config).ImportContext(ctx); err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := os.Stat(checkpoint); err != nil {
+		t.Fatalf("expected a checkpoint: %s", err)
+	}
+
+	// Resume it against a second server.
+	s1 := NewServer()
+	defer s1.Close()
+	config = v8.NewV8Config("", "", "", "", path, "test", s1.URL(), false, 10)
+	config.CheckpointFile = checkpoint
+	i := v8.NewV8(config)
+	if err := i.Import(); err != nil {
+		t.Fatal(err)
+	} else if n := i.Summary().TotalInserts; n != 1000 {
+		t.Fatalf("unexpected total inserts: %d", n)
+	} else if n := len(s1.Queries()); n != 0 {
+		t.Fatalf("unexpected queries on resume: %d", n)
+	} else if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+		t.Fatalf("expected the checkpoint to be removed: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, w := range append(s0.Writes(), s1.Writes()...) {
+		for _, l := range strings.Split(w, "\n") {
+			if seen[l] {
+				t.Fatalf("line written twice: %s", l)
+			}
+			seen[l] = true
+		}
+	}
+	if len(seen) != 1000 {
+		t.Fatalf("unexpected number of lines written: %d", len(seen))
+	}
+}
+
+// Ensure that batches are split so that no write is larger than MaxBatchBytes.
+func TestV8_Import_MaxBatchBytes(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\n" +
+		"cpu value=1 1\ncpu value=2 2\ncpu value=3 3\n" +
+		"cpu,host=serverA value=4 4\ncpu value=5 5\n")
+	defer os.Remove(path)
+
+	// Two short lines and a newline are exactly 27 bytes.
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 100)
+	config.MaxBatchBytes = 27
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{
+		"cpu value=1 1\ncpu value=2 2",
+		"cpu value=3 3",
+		"cpu,host=serverA value=4 4",
+		"cpu value=5 5",
+	}
+	if !reflect.DeepEqual(s.Writes(), exp) {
+		t.Fatalf("unexpected writes:\n\nexp=%#v\n\ngot=%#v", exp, s.Writes())
+	}
+}
+
+// Ensure that a CONTEXT-PRECISION header sets the precision of the writes that
+// follow it, unless a precision was configured.
+func TestV8_Import_ContextPrecision(t *testing.T) {
+	for _, tt := range []struct {
+		precision string
+		exp       []string
+		writes    []string
+	}{
+		{precision: "", exp: []string{"", "s", "ms"}, writes: []string{
+			"cpu value=1 1434055562000000000",
+			"cpu value=2 1434055562\ncpu value=3 1434055563",
+			"cpu value=4 1434055562000",
+		}},
+		// A configured precision applies to every line, so they share a batch
+		{precision: "u", exp: []string{"u"}, writes: []string{
+			"cpu value=1 1434055562000000000\ncpu value=2 1434055562\ncpu value=3 1434055563\ncpu value=4 1434055562000",
+		}},
+	} {
+		s := NewServer()
+		path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\n" +
+			"cpu value=1 1434055562000000000\n" +
+			"# CONTEXT-PRECISION:s\ncpu value=2 1434055562\ncpu value=3 1434055563\n" +
+			"# CONTEXT-PRECISION: ms\ncpu value=4 1434055562000\n")
+
+		if err := v8.NewV8(v8.NewV8Config("", "", tt.precision, "", path, "test", s.URL(), false, 0)).Import(); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, p := range s.WriteParams() {
+			got = append(got, p.Get("precision"))
+		}
+		if !reflect.DeepEqual(got, tt.exp) {
+			t.Fatalf("unexpected precisions for %q: exp=%q, got=%q", tt.precision, tt.exp, got)
+		} else if !reflect.DeepEqual(s.Writes(), tt.writes) {
+			t.Fatalf("unexpected writes: %q", s.Writes())
+		}
+		os.Remove(path)
+		s.Close()
+	}
+}
+
+// Ensure that an unknown precision or write consistency fails the import
+// before anything is sent.
+func TestV8_Import_InvalidConfig(t *testing.T) {
+	for _, tt := range []struct {
+		precision, consistency, err string
+	}{
+		{precision: "milliseconds", err: `unknown precision "milliseconds", expected one of n, u, ms, s, m or h`},
+		{consistency: "most", err: `unknown write consistency "most", expected one of any, one, quorum or all`},
+	} {
+		s := NewServer()
+		path := MustWriteTempFile(dump)
+
+		err := v8.NewV8(v8.NewV8Config("", "", tt.precision, tt.consistency, path, "test", s.URL(), false, 0)).Import()
+		if err == nil || err.Error() != tt.err {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(s.Queries()) != 0 || len(s.Writes()) != 0 {
+			t.Fatalf("unexpected requests: %q, %q", s.Queries(), s.Writes())
+		}
+		os.Remove(path)
+		s.Close()
+	}
+}
+
+// Ensure that an unknown CONTEXT-PRECISION fails the import.
+func TestV8_Import_ContextPrecision_Unknown(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\n# CONTEXT-PRECISION:us\ncpu value=1 1\n")
+	defer os.Remove(path)
+
+	err := v8.NewV8(v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)).Import()
+	if err == nil || !strings.Contains(err.Error(), `unknown precision "us"`) || !strings.Contains(err.Error(), ":3") {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(s.Writes()) != 0 {
+		t.Fatalf("unexpected writes: %q", s.Writes())
+	}
+}
+
+// Ensure that the summary counts the inserts written to each measurement.
+func TestV8_Import_MeasurementSummary(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.RejectLine = func(line string) bool { return strings.HasPrefix(line, "disk") }
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\n" +
+		"cpu value=1 1\nmem value=1 1\ncpu value=2 2\n" +
+		"cpu\\ load value=1 1\ndisk value=1 1\n")
+	defer os.Remove(path)
+
+	logger := &Logger{}
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 3)
+	config.MeasurementSummary = true
+	config.Quiet = true
+	config.Logger = logger
+	i := v8.NewV8(config)
+	if err := i.Import(); !errors.Is(err, v8.ErrPartialImport) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if exp := map[string]int{"cpu": 2, "mem": 1}; !reflect.DeepEqual(i.Summary().Measurements, exp) {
+		t.Fatalf("unexpected measurements: %v", i.Summary().Measurements)
+	}
+	msgs := logger.Messages()
+	if len(msgs) < 2 || msgs[len(msgs)-2] != "Processed 2 inserts into cpu\n" || msgs[len(msgs)-1] != "Processed 1 inserts into mem\n" {
+		t.Fatalf("unexpected messages: %q", msgs)
+	}
+}
+
+// Ensure that inserts are sent over UDP when it is enabled, while DDL still
+// goes over HTTP.
+func TestV8_Import_UDP(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.UDP = true
+	config.UDPAddr = conn.LocalAddr().String()
+	config.UDPPayloadSize = 60
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"cpu,host=server01 value=1 1434055562000000000",
+		"cpu,host=server02 value=2 1434055562000000000",
+		"mem,host=server01 value=3 1434055562000000000",
+	}
+	var got []string
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for len(got) < len(exp) {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(buf[:n]))
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected payloads:\n\nexp=%#v\n\ngot=%#v", exp, got)
+	} else if len(s.Writes()) != 0 {
+		t.Fatalf("unexpected http writes: %q", s.Writes())
+	} else if len(s.Queries()) == 0 {
+		t.Fatal("expected ddl over http")
+	}
+}
+
+// Ensure that Transform can rewrite or drop each line before it is validated and batched.
+func TestV8_Import_Transform(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\n" +
+		"cpu,host=SERVER01 value=1 1\ncpu,host=server02,old=x value=2 2\ncpu\n" +
+		"cpu,host=SERVER01 value=1 1\n")
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.Transform = func(line string) (string, bool) {
+		if line == "cpu" {
+			return line, false
+		}
+		return strings.Replace(strings.ToLower(line), ",old=x", "", 1), true
+	}
+	config.ValidateLines = true
+	config.Dedup = true
+	i := v8.NewV8(config)
+	if err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"cpu,host=server02 value=2 2\ncpu,host=server01 value=1 1"}; !reflect.DeepEqual(s.Writes(), exp) {
+		t.Fatalf("unexpected writes:\n\nexp=%#v\n\ngot=%#v", exp, s.Writes())
+	} else if sum := i.Summary(); sum.Skipped != 1 || sum.Rejected != 0 || sum.Duplicates != 1 {
+		t.Fatalf("unexpected summary: %#v", sum)
+	}
+}
+
+// Ensure that every batch and command is also sent to each replica, and that
+// a replica's failures are only counted against it.
+func TestV8_Import_ReplicaURLs(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	replica := NewServer()
+	defer replica.Close()
+	replica.WriteStatus = func(n int) int {
+		if n == 1 {
+			return http.StatusBadRequest
+		}
+		return http.StatusNoContent
+	}
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 1)
+	config.ReplicaURLs = []url.URL{replica.URL()}
+	config.Quiet = true
+	i := v8.NewV8(config)
+	if err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(replica.Queries(), s.Queries()) || len(s.Queries()) != 2 {
+		t.Fatalf("unexpected replica queries: %q", replica.Queries())
+	} else if len(s.Writes()) != 3 || len(replica.Writes()) != 2 {
+		t.Fatalf("unexpected writes: %q, %q", s.Writes(), replica.Writes())
+	}
+	sum := i.Summary()
+	if sum.TotalInserts != 3 || sum.FailedInserts != 0 {
+		t.Fatalf("unexpected summary: %#v", sum)
+	}
+	u := replica.URL()
+	exp := []v8.TargetSummary{{URL: u.String(), TotalInserts: 2, FailedInserts: 1, FailedBatches: 1}}
+	if !reflect.DeepEqual(sum.Replicas, exp) {
+		t.Fatalf("unexpected replica summary:\n\nexp=%#v\n\ngot=%#v", exp, sum.Replicas)
+	}
+}
+
+// Ensure that a partial write to a replica only counts the points it dropped
+// as failed.
+func TestV8_Import_ReplicaPartialWrite(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	replica := NewServer()
+	defer replica.Close()
+	replica.WriteStatus = func(n int) int { return http.StatusOK }
+	replica.WriteBody = func(n int) string { return `{"error":"partial write: field type conflict dropped=1"}` }
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.ReplicaURLs = []url.URL{replica.URL()}
+	config.Quiet = true
+	i := v8.NewV8(config)
+	if err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := i.Summary()
+	if sum.TotalInserts != 3 || sum.FailedInserts != 0 {
+		t.Fatalf("unexpected summary: %#v", sum)
+	}
+	u := replica.URL()
+	exp := []v8.TargetSummary{{URL: u.String(), TotalInserts: 2, FailedInserts: 1}}
+	if !reflect.DeepEqual(sum.Replicas, exp) {
+		t.Fatalf("unexpected replica summary:\n\nexp=%#v\n\ngot=%#v", exp, sum.Replicas)
+	}
+}
+
+// Ensure that writes pause while the server is unreachable, and that the
+// batch that found it down is written again once it comes back.
+func TestV8_Import_Breaker(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	var writes, dropped int
+	s.Drop = func(path string) bool {
+		if path == "/write" {
+			writes++
+		}
+		// Drop the 2nd and 3rd writes and the 3 pings after them
+		if writes > 1 && dropped < 5 {
+			dropped++
+			return true
+		}
+		return false
+	}
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\ncpu value=1 1\ncpu value=2 2\ncpu value=3 3\ncpu value=4 4\n")
+	defer os.Remove(path)
+
+	logger := &Logger{}
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 1)
+	config.RetryBackoff = 10 * time.Millisecond
+	config.BreakerThreshold = 2
+	config.Logger = logger
+	config.Quiet = true
+	i := v8.NewV8(config)
+	if err := i.Import(); !errors.Is(err, v8.ErrPartialImport) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if exp := []string{"cpu value=1 1", "cpu value=3 3", "cpu value=4 4"}; !reflect.DeepEqual(s.Writes(), exp) {
+		t.Fatalf("unexpected writes:\n\nexp=%#v\n\ngot=%#v", exp, s.Writes())
+	} else if sum := i.Summary(); sum.FailedInserts != 1 || sum.TotalInserts != 3 {
+		t.Fatalf("unexpected summary: %#v", sum)
+	}
+	msgs := strings.Join(logger.Messages(), "")
+	if !strings.Contains(msgs, "Server unreachable after 2 failed batches, pausing writes for up to 5m0s\n") ||
+		!strings.Contains(msgs, "Server reachable again, resuming writes\n") {
+		t.Fatalf("unexpected messages: %q", logger.Messages())
+	}
+}
+
+// Ensure that the import is aborted if the server stays unreachable for
+// longer than BreakerMaxWait.
+func TestV8_Import_Breaker_MaxWait(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	var writes int
+	s.Drop = func(path string) bool {
+		if path == "/write" {
+			writes++
+		}
+		return writes > 1
+	}
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\ncpu value=1 1\ncpu value=2 2\ncpu value=3 3\ncpu value=4 4\n")
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 1)
+	config.RetryBackoff = 10 * time.Millisecond
+	config.BreakerThreshold = 1
+	config.BreakerMaxWait = 50 * time.Millisecond
+	config.Logger = &Logger{}
+	config.Quiet = true
+	err := v8.NewV8(config).Import()
+	if err == nil || err.Error() != "aborted after the server was unreachable for 50ms" {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(s.Writes()) != 1 {
+		t.Fatalf("unexpected writes: %q", s.Writes())
+	}
+}
+
+// Ensure that progress is logged every ProgressInterval while an import runs.
+func TestV8_Import_ProgressInterval(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteDelay = 50 * time.Millisecond
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	logger := &Logger{}
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 1)
+	config.ProgressInterval = 20 * time.Millisecond
+	config.Logger = logger
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress []string
+	for _, msg := range logger.Messages() {
+		if strings.HasPrefix(msg, "Progress: ") {
+			progress = append(progress, msg)
+		}
+	}
+	if len(progress) < 2 {
+		t.Fatalf("unexpected progress messages: %q", logger.Messages())
+	} else if !strings.Contains(progress[0], " inserts/sec, 0 failed, ") || !strings.HasSuffix(progress[0], "% read\n") {
+		t.Fatalf("unexpected progress message: %q", progress[0])
+	}
+}
+
+// Ensure that batches are counted as in flight until they have been written.
+func TestV8_InFlightBatches(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteDelay = 100 * time.Millisecond
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	i := v8.NewV8(v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 1))
+	errs := make(chan error)
+	go func() { errs <- i.Import() }()
+
+	var max int
+	for done := false; !done; {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatal(err)
+			}
+			done = true
+		case <-time.After(10 * time.Millisecond):
+			if n := i.InFlightBatches(); n > max {
+				max = n
+			}
+		}
+	}
+	if max == 0 {
+		t.Fatal("expected batches in flight during import")
+	} else if n := i.InFlightBatches(); n != 0 {
+		t.Fatalf("unexpected batches in flight after import: %d", n)
+	}
+}
+
+// Ensure that no more than MaxPendingBatches full batches wait for the writers.
+func TestV8_Import_MaxPendingBatches(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteDelay = 20 * time.Millisecond
+
+	var buf bytes.Buffer
+	buf.WriteString("# DML\n# CONTEXT-DATABASE:db0\n")
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&buf, "cpu value=%d\n", i)
+	}
+	path := MustWriteTempFile(buf.String())
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 1)
+	config.MaxPendingBatches = 3
+	i := v8.NewV8(config)
+	errs := make(chan error)
+	go func() { errs <- i.Import() }()
+
+	var max int
+	for done := false; !done; {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatal(err)
+			}
+			done = true
+		case <-time.After(5 * time.Millisecond):
+			if n := i.InFlightBatches(); n > max {
+				max = n
+			}
+		}
+	}
+	// One being written, three waiting and one the accumulator is handing off
+	if max != 5 {
+		t.Fatalf("unexpected batches in flight: %d", max)
+	} else if n := len(s.Writes()); n != 20 {
+		t.Fatalf("unexpected write count: %d", n)
+	}
+}
+
+// Ensure that Generate writes the points it describes through the batches.
+func TestV8_Import_Generate(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	config := v8.NewV8Config("", "", "s", "", "", "test", s.URL(), false, 4)
+	config.Generate = &v8.GenerateConfig{
+		Database:        "db0",
+		RetentionPolicy: "rp0",
+		Measurement:     "cpu",
+		Tags:            map[string]int{"region": 2, "host": 3},
+		Fields:          []string{"idle", "user"},
+		Points:          10,
+		Start:           time.Unix(100, 0),
+		Interval:        10 * time.Second,
+	}
+	i := v8.NewV8(config)
+	if err := i.Import(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if sum := i.Summary(); sum.TotalInserts != 10 {
+		t.Fatalf("unexpected summary: %+v", sum)
+	}
+
+	writes := s.Writes()
+	if len(writes) != 3 {
+		t.Fatalf("unexpected write count: %d", len(writes))
+	} else if p := s.WriteParams()[0]; p.Get("db") != "db0" || p.Get("rp") != "rp0" || p.Get("precision") != "s" {
+		t.Fatalf("unexpected write params: %v", p)
+	}
+	lines := strings.Split(strings.Join(writes, "\n"), "\n")
+	exp := []string{
+		"cpu,host=host0,region=region0 100", "cpu,host=host0,region=region1 100",
+		"cpu,host=host1,region=region0 100", "cpu,host=host1,region=region1 100",
+		"cpu,host=host2,region=region0 100", "cpu,host=host2,region=region1 100",
+		"cpu,host=host0,region=region0 110", "cpu,host=host0,region=region1 110",
+		"cpu,host=host1,region=region0 110", "cpu,host=host1,region=region1 110",
+	}
+	for n, l := range lines {
+		pts, err := tsdb.ParsePointsString(l)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %v", l, err)
+		}
+		fields := pts[0].Fields()
+		if _, ok := fields["idle"].(float64); !ok || len(fields) != 2 {
+			t.Fatalf("unexpected fields in %q", l)
+		}
+		parts := strings.Split(l, " ")
+		if got := parts[0] + " " + parts[2]; got != exp[n] {
+			t.Fatalf("unexpected line %d: %q", n, l)
+		}
+	}
+
+	// The points must be described well enough to be written
+	config.Generate = &v8.GenerateConfig{Database: "db0", Measurement: "cpu"}
+	if err := v8.NewV8(config).Import(); err == nil || !strings.Contains(err.Error(), "points to generate") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that Output is written a cleaned export, which imports as the
+// server would have been written, instead of sending anything to the server.
+func TestV8_Import_Output(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	var buf bytes.Buffer
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.ExcludeMeasurements = []string{"mem"}
+	config.MeasurementRename = map[string]string{"cpu": "processor"}
+	config.Output = &buf
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if n := s.Attempts(); n != 0 {
+		t.Fatalf("unexpected write attempts: %d", n)
+	} else if q := s.Queries(); len(q) != 0 {
+		t.Fatalf("unexpected queries: %q", q)
+	}
+	exp := `# DDL
+CREATE DATABASE db0
+CREATE RETENTION POLICY rp0 ON db0 DURATION 1h REPLICATION 1
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:rp0
+processor,host=server01 value=1 1434055562000000000
+processor,host=server02 value=2 1434055562000000000
+`
+	if buf.String() != exp {
+		t.Fatalf("unexpected output:\n\nexp=%q\n\ngot=%q", exp, buf.String())
+	}
+
+	cleaned := MustWriteTempFile(buf.String())
+	defer os.Remove(cleaned)
+	if err := v8.NewV8(v8.NewV8Config("", "", "", "", cleaned, "test", s.URL(), false, 0)).Import(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if q := s.Queries(); len(q) != 2 {
+		t.Fatalf("unexpected queries: %q", q)
+	} else if w := s.Writes(); len(w) != 1 || w[0] != "processor,host=server01 value=1 1434055562000000000\nprocessor,host=server02 value=2 1434055562000000000" {
+		t.Fatalf("unexpected writes: %q", w)
+	}
+}
+
+// Ensure that the output goes back to nanoseconds after lines written with
+// another precision, so that it imports with the timestamps it was read with.
+func TestV8_Import_Output_PrecisionReset(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\nmem value=1 1\ncpu value=2 2000000000\n")
+	defer os.Remove(path)
+
+	var buf bytes.Buffer
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.MeasurementOverrides = map[string]v8.WriteOverride{"mem": {Precision: "s"}}
+	config.Output = &buf
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := "# DML\n# CONTEXT-DATABASE:db0\n" +
+		"# CONTEXT-PRECISION:s\nmem value=1 1\n# CONTEXT-PRECISION:n\ncpu value=2 2000000000\n"
+	if buf.String() != exp {
+		t.Fatalf("unexpected output:\n\nexp=%q\n\ngot=%q", exp, buf.String())
+	}
+
+	cleaned := MustWriteTempFile(buf.String())
+	defer os.Remove(cleaned)
+	if err := v8.NewV8(v8.NewV8Config("", "", "", "", cleaned, "test", s.URL(), false, 0)).Import(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, p := range s.points("db0", "") {
+		if exp := time.Unix(int64(p.Fields()["value"].(int64)), 0); !p.Time().Equal(exp) {
+			t.Fatalf("unexpected time of %s: %s", p.Name(), p.Time())
+		}
+	}
+	if n := len(s.points("db0", "")); n != 2 {
+		t.Fatalf("unexpected points: %d", n)
+	}
+}
+
+// Ensure that repeated lines are only written once per batch.
+func TestV8_Import_Dedup(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\n" +
+		"cpu value=1 1\ncpu value=2 2\ncpu value=1 1\n" +
+		"cpu value=1 1\ncpu value=3 3\ncpu value=3 3\n")
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 3)
+	config.Dedup = true
+	i := v8.NewV8(config)
+	if err := i.Import(); err != nil {
+		t.Fatal(err)
+	}
+	// The repeat in the second batch is still written.
+	exp := []string{"cpu value=2 2\ncpu value=1 1", "cpu value=1 1\ncpu value=3 3"}
+	if !reflect.DeepEqual(s.Writes(), exp) {
+		t.Fatalf("unexpected writes:\n\nexp=%#v\n\ngot=%#v", exp, s.Writes())
+	} else if sum := i.Summary(); sum.Duplicates != 2 || sum.TotalInserts != 4 {
+		t.Fatalf("unexpected summary: %#v", sum)
+	}
+}
+
+// Ensure that SortBatch writes each batch in timestamp order.
+func TestV8_Import_SortBatch(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile("# DML\n# CONTEXT-DATABASE:db0\n" +
+		"cpu value=3 30\nmem value=1\ncpu value=1 10\n" +
+		"mem value=2 20\ncpu,host=a\\ b msg=\"x 5\"\nmem value=0 -5\n")
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 10)
+	config.SortBatch = true
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"mem value=0 -5\ncpu value=1 10\nmem value=2 20\ncpu value=3 30\nmem value=1\ncpu,host=a\\ b msg=\"x 5\""}
+	if !reflect.DeepEqual(s.Writes(), exp) {
+		t.Fatalf("unexpected writes:\n\nexp=%#v\n\ngot=%#v", exp, s.Writes())
+	}
+}
+
+// Ensure that the database and retention policy of a dump without DDL are
+// created before they are written to.
+func TestV8_Import_AutoCreate(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.QueryError = func(q string) string {
+		for _, p := range s.WriteParams() {
+			if q == "CREATE DATABASE "+p.Get("db") {
+				t.Errorf("database created after a write to it: %s", q)
+			}
+		}
+		if q == "CREATE DATABASE db1" {
+			return "database already exists"
+		}
+		return ""
+	}
+
+	path := MustWriteTempFile(`# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:rp0
+cpu value=1 1434055562000000000
+cpu value=2 1434055563000000000
+# CONTEXT-DATABASE:db1
+cpu value=3 1434055562000000000
+# CONTEXT-DATABASE:db0
+cpu value=4 1434055564000000000
+`)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.AutoCreateDatabase = true
+	config.AutoCreateRetentionPolicy = true
+	config.StrictDDL = true
+	i := v8.NewV8(config)
+	if err := i.Import(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []string{
+		"CREATE DATABASE db0",
+		"CREATE RETENTION POLICY rp0 ON db0 DURATION INF REPLICATION 1",
+		"CREATE DATABASE db1",
+		"CREATE RETENTION POLICY rp0 ON db1 DURATION INF REPLICATION 1",
+	}; !reflect.DeepEqual(s.Queries(), exp) {
+		t.Fatalf("unexpected queries: %#v", s.Queries())
+	}
+	if s := i.Summary(); s.TotalCommands != 4 || s.FailedCommands != 0 {
+		t.Fatalf("unexpected summary: %+v", s)
+	}
+}
+
+// Ensure that files read in parallel don't wait for each other's databases
+// to be created.
+func TestV8_Import_AutoCreate_FileConcurrency(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.QueryError = func(q string) string {
+		if q != "CREATE DATABASE db0" {
+			return ""
+		}
+		// Only answer once the other file has been written
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			for _, p := range s.WriteParams() {
+				if p.Get("db") == "db1" {
+					return ""
+				}
+			}
+		}
+		t.Error("creating db0 held up the writes to db1")
+		return ""
+	}
+
+	var files []string
+	for i := 0; i < 2; i++ {
+		path := MustWriteTempFile(fmt.Sprintf("# DML\n# CONTEXT-DATABASE:db%d\ncpu value=1 1434055562000000000\n", i))
+		defer os.Remove(path)
+		files = append(files, path)
+	}
+
+	config := v8.NewV8Config("", "", "", "", "", "test", s.URL(), false, 1)
+	config.AutoCreateDatabase = true
+	config.FileConcurrency = 2
+	i := v8.NewV8(config)
+	if err := i.ImportFiles(files); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if s := i.Summary(); s.TotalInserts != 2 || s.TotalCommands != 2 {
+		t.Fatalf("unexpected summary: %+v", s)
+	}
+}
+
+// Ensure that nothing is created for a database and retention policy the DDL creates.
+func TestV8_Import_AutoCreate_DDL(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.AutoCreateDatabase = true
+	config.AutoCreateRetentionPolicy = true
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []string{"CREATE DATABASE db0", "CREATE RETENTION POLICY rp0 ON db0 DURATION 1h REPLICATION 1"}; !reflect.DeepEqual(s.Queries(), exp) {
+		t.Fatalf("unexpected queries: %#v", s.Queries())
+	}
+}
+
+// Ensure that only points within StartTime and EndTime are written, reading
+// timestamps with the precision they are written with.
+func TestV8_Import_TimeWindow(t *testing.T) {
+	content := `# DML
+# CONTEXT-DATABASE:db0
+cpu value=1 1434055561000000000
+cpu value=2 1434055562000000000
+cpu value=3 1434055562999999999
+cpu value=4 1434055563000000000
+cpu value=5
+# CONTEXT-PRECISION:s
+cpu value=6 1434055561
+cpu value=7 1434055562
+`
+	for _, tt := range []struct {
+		skipUntimed bool
+		exp         []string
+		skipped     int
+	}{
+		{false, []string{"cpu value=2 1434055562000000000\ncpu value=3 1434055562999999999\ncpu value=5", "cpu value=7 1434055562"}, 3},
+		{true, []string{"cpu value=2 1434055562000000000\ncpu value=3 1434055562999999999", "cpu value=7 1434055562"}, 4},
+	} {
+		s := NewServer()
+		path := MustWriteTempFile(content)
+		defer os.Remove(path)
+
+		config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+		config.StartTime = time.Unix(1434055562, 0)
+		config.EndTime = time.Unix(1434055563, 0)
+		config.SkipUntimedLines = tt.skipUntimed
+		i := v8.NewV8(config)
+		if err := i.Import(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(s.Writes(), tt.exp) {
+			t.Fatalf("unexpected writes:\n\nexp=%#v\n\ngot=%#v", tt.exp, s.Writes())
+		}
+		if n := i.Summary().Skipped; n != tt.skipped {
+			t.Fatalf("unexpected skipped count: %d", n)
+		}
+		s.Close()
+	}
+}
+
+// Ensure that the DDL has been executed before any lines are written, even
+// when the server is slow to execute it.
+func TestV8_Import_DDLBeforeDML(t *testing.T) {
+	for n := 0; n < 20; n++ {
+		var created int32
+		s := NewServer()
+		s.QueryError = func(q string) string {
+			time.Sleep(5 * time.Millisecond)
+			if q == "CREATE DATABASE db0" {
+				atomic.StoreInt32(&created, 1)
+			}
+			return ""
+		}
+		s.WriteStatus = func(int) int {
+			if atomic.LoadInt32(&created) == 0 {
+				return http.StatusNotFound
+			}
+			return http.StatusNoContent
+		}
+
+		path := MustWriteTempFile(dump)
+		config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 1)
+		config.Concurrency = 4
+		i := v8.NewV8(config)
+		err := i.Import()
+		s.Close()
+		os.Remove(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if s := i.Summary(); s.FailedInserts != 0 {
+			t.Fatalf("unexpected summary: %+v", s)
+		}
+	}
+}
+
+// Ensure that DDLMarker and DMLMarker replace the markers that start the
+// sections, and that failed lines are saved under DMLMarker.
+func TestV8_Import_Markers(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteStatus = func(int) int { return http.StatusBadRequest }
+
+	path := MustWriteTempFile("-- schema\nCREATE DATABASE db0\n\n-- data\n# CONTEXT-DATABASE:db0\ncpu value=1 1\n")
+	defer os.Remove(path)
+	failed := path + ".failed"
+	defer os.Remove(failed)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.DDLMarker, config.DMLMarker = "-- schema", "-- data"
+	config.FailedLinesFile = failed
+	config.Quiet = true
+	if err := v8.NewV8(config).Import(); !errors.Is(err, v8.ErrPartialImport) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if q := s.Queries(); !reflect.DeepEqual(q, []string{"CREATE DATABASE db0"}) {
+		t.Fatalf("unexpected queries: %q", q)
+	}
+	if b, err := ioutil.ReadFile(failed); err != nil {
+		t.Fatal(err)
+	} else if !strings.HasPrefix(string(b), "-- data\n") || !strings.Contains(string(b), "\ncpu value=1 1\n") {
+		t.Fatalf("unexpected failed lines file: %q", b)
+	}
+}
+
+// Ensure that files read in parallel are each written to their own context,
+// and that only the first file's DDL is executed, before any lines are written.
+func TestV8_ImportFiles_FileConcurrency(t *testing.T) {
+	var created int32
+	s := NewServer()
+	defer s.Close()
+	s.QueryError = func(q string) string {
+		time.Sleep(5 * time.Millisecond)
+		if q == "CREATE DATABASE db0" {
+			atomic.StoreInt32(&created, 1)
+		}
+		return ""
+	}
+	s.WriteStatus = func(int) int {
+		if atomic.LoadInt32(&created) == 0 {
+			return http.StatusNotFound
+		}
+		return http.StatusNoContent
+	}
+
+	var files []string
+	for i := 0; i < 8; i++ {
+		var content string
+		if i < 2 {
+			content = fmt.Sprintf("# DDL\nCREATE DATABASE db%d\n\n", i)
+		}
+		content += fmt.Sprintf("# DML\n# CONTEXT-DATABASE:db%d\n", i%2)
+		for j := 0; j < 10; j++ {
+			content += fmt.Sprintf("cpu,file=%d value=%d\n", i, j)
+		}
+		path := MustWriteTempFile(content)
+		defer os.Remove(path)
+		files = append(files, path)
+	}
+
+	config := v8.NewV8Config("", "", "", "", "", "test", s.URL(), false, 3)
+	config.Concurrency = 2
+	config.FileConcurrency = 4
+	i := v8.NewV8(config)
+	if err := i.ImportFiles(files); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if s := i.Summary(); s.TotalInserts != 80 || s.FailedInserts != 0 || s.TotalCommands != 1 {
+		t.Fatalf("unexpected summary: %+v", s)
+	}
+
+	if q := s.Queries(); !reflect.DeepEqual(q, []string{"CREATE DATABASE db0"}) {
+		t.Fatalf("unexpected queries: %q", q)
+	}
+	writes, params := s.Writes(), s.WriteParams()
+	n := 0
+	for w, lines := range writes {
+		for _, line := range strings.Split(lines, "\n") {
+			var file, value int
+			if _, err := fmt.Sscanf(line, "cpu,file=%d value=%d", &file, &value); err != nil {
+				t.Fatalf("unexpected line: %q", line)
+			} else if db := params[w].Get("db"); db != fmt.Sprintf("db%d", file%2) {
+				t.Fatalf("unexpected database for %q: %s", line, db)
+			}
+			n++
+		}
+	}
+	if n != 80 {
+		t.Fatalf("unexpected line count: %d", n)
+	}
+}
+
+// Ensure that the DDL of every file in an archive is read in the archive's
+// turn when files are read in parallel, before the files after it.
+func TestV8_ImportFiles_FileConcurrency_Archive(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := 0; i < 3; i++ {
+		content := fmt.Sprintf("# DDL\nCREATE DATABASE archive%d\n\n# DML\n# CONTEXT-DATABASE:archive%d\ncpu value=%d\n", i, i, i)
+		if err := tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("db%d.txt", i), Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		} else if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive := MustWriteTempFile(buf.String())
+	defer os.Remove(archive)
+
+	files := []string{archive}
+	for i := 0; i < 4; i++ {
+		path := MustWriteTempFile(fmt.Sprintf("# DDL\nCREATE DATABASE file%d\n\n# DML\n# CONTEXT-DATABASE:file%d\ncpu value=%d\n", i, i, i))
+		defer os.Remove(path)
+		files = append(files, path)
+	}
+
+	config := v8.NewV8Config("", "", "", "", "", "test", s.URL(), false, 1)
+	config.FileConcurrency = 4
+	i := v8.NewV8(config)
+	if err := i.ImportFiles(files); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if s := i.Summary(); s.TotalInserts != 7 || s.TotalCommands != 1 {
+		t.Fatalf("unexpected summary: %+v", s)
+	}
+	if q := s.Queries(); !reflect.DeepEqual(q, []string{"CREATE DATABASE archive0"}) {
+		t.Fatalf("unexpected queries: %q", q)
+	}
+}
+
+// Ensure that FileConcurrency can't be combined with a checkpoint.
+func TestV8_ImportFiles_FileConcurrency_Checkpoint(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	config := v8.NewV8Config("", "", "", "", "", "test", s.URL(), false, 0)
+	config.FileConcurrency = 2
+	config.CheckpointFile = filepath.Join(os.TempDir(), "influxdb-v8-checkpoint")
+	if err := v8.NewV8(config).ImportFiles([]string{"a", "b"}); err == nil || !strings.Contains(err.Error(), "FileConcurrency") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// BenchmarkV8_ImportFiles compares importing a directory of 100 files one
+// at a time and several at once.
+func BenchmarkV8_ImportFiles(b *testing.B) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteDelay = time.Millisecond
+
+	dir, err := ioutil.TempDir("", "influxdb-v8-")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i := 0; i < 100; i++ {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "# DML\n# CONTEXT-DATABASE:db0\n")
+		for j := 0; j < 2500; j++ {
+			fmt.Fprintf(&buf, "cpu,host=server%02d,region=us-west value=%d.5,load=%d %d\n", i, j, j, 1434055562000000000+int64(j))
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%03d.txt", i)), buf.Bytes(), 0600); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	for _, n := range []int{1, 4} {
+		b.Run(fmt.Sprintf("FileConcurrency=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				config := v8.NewV8Config("", "", "", "", filepath.Join(dir, "*.txt"), "test", s.URL(), false, 1000)
+				config.Concurrency = 4
+				config.FileConcurrency = n
+				config.ValidateLines = true
+				config.Logger = &Logger{}
+				if err := v8.NewV8(config).Import(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// Ensure that an unknown compression format is rejected.
+func TestV8_Import_UnknownCompression(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.Compression = "lzma"
+	if err := v8.NewV8(config).Import(); err == nil || err.Error() != `unknown compression "lzma"` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that an export can be imported again, recreating the same points.
+func TestExporter_Export(t *testing.T) {
+	for _, compressed := range []bool{false, true} {
+		src := NewServer()
+		defer src.Close()
+		src.QueryResults = func(db, q string) string {
+			switch {
+			case q == "SHOW RETENTION POLICIES ON db0":
+				return `[{"series":[{"columns":["name","duration","replicaN","default"],"values":[["rp0","1h0m0s",1,true]]}]}]`
+			case q == "SHOW MEASUREMENTS":
+				return `[{"series":[{"name":"measurements","columns":["name"],"values":[["cpu"],["mem"]]}]}]`
+			case strings.HasPrefix(q, `SELECT * FROM "db0"."rp0".cpu `) && strings.HasSuffix(q, "OFFSET 0"):
+				return `[{"series":[` +
+					`{"name":"cpu","tags":{"host":"server01"},"columns":["time","value","up"],"values":[["2015-06-11T20:46:02Z",1,true],["2015-06-11T20:46:03Z",1.5,null]]},` +
+					`{"name":"cpu","tags":{"host":"server 02"},"columns":["time","value","up"],"values":[["2015-06-11T20:46:02Z",2,false]]}]}]`
+			case strings.HasPrefix(q, `SELECT * FROM "db0"."rp0".cpu `) && strings.HasSuffix(q, "OFFSET 2"):
+				return `[{"series":[{"name":"cpu","tags":{"host":"server01"},"columns":["time","value","up"],"values":[["2015-06-11T20:46:04.5Z",3,true]]}]}]`
+			}
+			return ""
+		}
+		c, err := client.NewClient(client.Config{URL: src.URL()})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		e := v8.NewExporter(c, v8.ExportConfig{Database: "db0", IncludeMeasurements: []string{"cpu"}, Compressed: compressed, ChunkSize: 2})
+		if err := e.Export(&buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exp := `SELECT * FROM "db0"."rp0".cpu GROUP BY * LIMIT 2 OFFSET 4`; src.Queries()[len(src.Queries())-1] != exp {
+			t.Fatalf("unexpected queries: %#v", src.Queries())
+		}
+
+		path := MustWriteTempFile(buf.String())
+		defer os.Remove(path)
+
+		dst := NewServer()
+		defer dst.Close()
+		config := v8.NewV8Config("", "", "", "", path, "test", dst.URL(), compressed, 0)
+		if err := v8.NewV8(config).Import(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exp := []string{"CREATE DATABASE db0", "CREATE RETENTION POLICY rp0 ON db0 DURATION 1h REPLICATION 1 DEFAULT"}; !reflect.DeepEqual(dst.Queries(), exp) {
+			t.Fatalf("unexpected queries: %#v", dst.Queries())
+		}
+		exp := []string{
+			"cpu,host=server01 up=true,value=1.0 1434055562000000000\n" +
+				"cpu,host=server01 value=1.5 1434055563000000000\n" +
+				"cpu,host=server\\ 02 up=false,value=2.0 1434055562000000000\n" +
+				"cpu,host=server01 up=true,value=3.0 1434055564500000000",
+		}
+		if !reflect.DeepEqual(dst.Writes(), exp) {
+			t.Fatalf("unexpected writes:\n\nexp=%#v\n\ngot=%#v", exp, dst.Writes())
+		}
+		if p := dst.WriteParams()[0]; p.Get("db") != "db0" || p.Get("rp") != "rp0" {
+			t.Fatalf("unexpected write params: %v", p)
+		}
+	}
+}
+
+// Ensure that a float field with whole values is exported as floats, so that
+// it doesn't conflict with its other values when imported, while integer
+// fields are still exported as integers.
+func TestExporter_Export_FloatFields(t *testing.T) {
+	src := NewServer()
+	defer src.Close()
+	src.QueryResults = func(db, q string) string {
+		switch {
+		case q == "SHOW RETENTION POLICIES ON db0":
+			return `[{"series":[{"columns":["name","duration","replicaN","default"],"values":[["rp0","0",1,true]]}]}]`
+		case q == "SHOW MEASUREMENTS":
+			return `[{"series":[{"name":"measurements","columns":["name"],"values":[["cpu"]]}]}]`
+		case strings.HasSuffix(q, "OFFSET 0"):
+			return `[{"series":[{"name":"cpu","columns":["time","value","count"],"values":[` +
+				`["2015-06-11T20:46:02Z",2,1],["2015-06-11T20:46:03Z",1.5,2]]}]}]`
+		}
+		return ""
+	}
+	c, err := client.NewClient(client.Config{URL: src.URL()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := v8.NewExporter(c, v8.ExportConfig{Database: "db0"}).Export(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := "cpu count=1,value=2.0 1434055562000000000\ncpu count=2,value=1.5 1434055563000000000\n"
+	if !strings.HasSuffix(buf.String(), exp) {
+		t.Fatalf("unexpected export: %q", buf.String())
+	}
+
+	points, err := tsdb.ParsePoints([]byte(exp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range points {
+		if _, ok := p.Fields()["value"].(float64); !ok {
+			t.Fatalf("unexpected value type: %T", p.Fields()["value"])
+		} else if _, ok := p.Fields()["count"].(int64); !ok {
+			t.Fatalf("unexpected count type: %T", p.Fields()["count"])
+		}
+	}
+}
+
+// Ensure that a float field is exported as floats even when its values are
+// whole for longer than a chunk, so that its type doesn't change part way.
+func TestExporter_Export_FloatFieldsChunked(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Schema = true
+	s.QueryResults = s.StoredResults
+
+	path := MustWriteTempFile(`# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:default
+cpu value=1.0 1434055562000000000
+cpu value=2.0 1434055563000000000
+cpu value=3.0 1434055564000000000
+cpu value=3.5 1434055565000000000
+`)
+	defer os.Remove(path)
+	if err := v8.NewV8(v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := client.NewClient(client.Config{URL: s.URL()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := v8.NewExporter(c, v8.ExportConfig{Database: "db0", ChunkSize: 2}).Export(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := "cpu value=1.0 1434055562000000000\n" +
+		"cpu value=2.0 1434055563000000000\n" +
+		"cpu value=3.0 1434055564000000000\n" +
+		"cpu value=3.5 1434055565000000000\n"
+	if !strings.HasSuffix(buf.String(), exp) {
+		t.Fatalf("unexpected export: %q", buf.String())
+	}
+}
+
+// Ensure that an export imports into a new server without failed commands,
+// altering the retention policy the server creates with the database rather
+// than creating it again.
+func TestExporter_Export_DefaultRetentionPolicy(t *testing.T) {
+	src := NewServer()
+	defer src.Close()
+	src.Schema = true
+	src.QueryResults = src.StoredResults
+
+	path := MustWriteTempFile(roundTripDump)
+	defer os.Remove(path)
+	if err := v8.NewV8(v8.NewV8Config("", "", "", "", path, "test", src.URL(), false, 0)).Import(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := client.NewClient(client.Config{URL: src.URL()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := v8.NewExporter(c, v8.ExportConfig{Database: "db0"}).Export(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	export := MustWriteTempFile(buf.String())
+	defer os.Remove(export)
+
+	dst := NewServer()
+	defer dst.Close()
+	dst.Schema = true
+	if err := v8.NewV8(v8.NewV8Config("", "", "", "", export, "test", dst.URL(), false, 0)).Import(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := []string{
+		"CREATE DATABASE db0",
+		"ALTER RETENTION POLICY default ON db0 DURATION INF REPLICATION 1",
+		"CREATE RETENTION POLICY rp0 ON db0 DURATION 1h REPLICATION 1 DEFAULT",
+	}
+	if !reflect.DeepEqual(dst.Queries(), exp) {
+		t.Fatalf("unexpected queries: %#v", dst.Queries())
+	} else if !reflect.DeepEqual(dst.RetentionPolicies("db0"), src.RetentionPolicies("db0")) {
+		t.Fatalf("unexpected retention policies: %v", dst.RetentionPolicies("db0"))
+	}
+}
+
+// Ensure that a database that is imported, exported and imported again into
+// another database verifies as identical.
+func TestExporter_Verify_RoundTrip(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Schema = true
+	s.QueryResults = s.StoredResults
+
+	e := RoundTrip(t, s, roundTripDump, "db0", "db1")
+	if err := e.Verify("db0", "db1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(s.points("db0", "")) != 7 || len(s.points("db1", "")) != 7 {
+		t.Fatalf("unexpected points: %d in db0, %d in db1", len(s.points("db0", "")), len(s.points("db1", "")))
+	}
+}
+
+// Ensure that points missing from the destination are reported.
+func TestExporter_Verify_Missing(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Schema = true
+	s.QueryResults = s.StoredResults
+
+	RoundTrip(t, s, roundTripDump, "db0", "db1")
+	path := MustWriteTempFile(dump)
+	defer os.Remove(path)
+	config := v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)
+	config.TargetDatabase = "db2"
+	config.IncludeMeasurements = []string{"cpu"}
+	config.IdempotentDDL = true
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c, _ := client.NewClient(client.Config{URL: s.URL()})
+	e := v8.NewExporter(c, v8.ExportConfig{})
+	if err := e.Verify("db0", "db2"); err == nil || err.Error() != `measurement cpu in rp0: 4 points in db0, 2 in db2` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// RoundTrip imports fixture, which must create database src, into s, exports
+// src and imports the export into dst. The export's DDL still names src, so
+// it is imported with IdempotentDDL. It returns the exporter used.
+func RoundTrip(t *testing.T, s *Server, fixture, src, dst string) *v8.Exporter {
+	path := MustWriteTempFile(fixture)
+	defer os.Remove(path)
+	if err := v8.NewV8(v8.NewV8Config("", "", "", "", path, "test", s.URL(), false, 0)).Import(); err != nil {
+		t.Fatalf("unexpected import error: %v", err)
+	}
+
+	c, err := client.NewClient(client.Config{URL: s.URL()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := v8.NewExporter(c, v8.ExportConfig{Database: src, ChunkSize: 2})
+	var buf bytes.Buffer
+	if err := e.Export(&buf); err != nil {
+		t.Fatalf("unexpected export error: %v", err)
+	}
+
+	export := MustWriteTempFile(buf.String())
+	defer os.Remove(export)
+	config := v8.NewV8Config("", "", "", "", export, "test", s.URL(), false, 0)
+	config.TargetDatabase = dst
+	config.IdempotentDDL = true
+	if err := v8.NewV8(config).Import(); err != nil {
+		t.Fatalf("unexpected re-import error: %v", err)
+	}
+	return e
+}
+
+// roundTripDump is a 0.8 export with escaping and precisions that a round
+// trip must preserve.
+const roundTripDump = `# DDL
+CREATE DATABASE db0
+CREATE RETENTION POLICY rp0 ON db0 DURATION 1h REPLICATION 1 DEFAULT
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:rp0
+cpu,host=server01 value=1.5,up=true 1434055562000000000
+cpu,host=server\ 02,region=us\,west value=2 1434055562000000001
+my\ cpu,host=server01 value=-3.25 1434055562000000000
+log,host=server01 msg="say \"hi\", then leave" 1434055562000000000
+# CONTEXT-PRECISION:s
+cpu,host=server01 value=4.5 1434055563
+cpu,host=server01 value=5.5 1434055564
+mem,host=server01 free=6 1434055562
+`
+
+// dump is a small 0.8 export used by the import tests.
+const dump = `# DDL
+CREATE DATABASE db0
+CREATE RETENTION POLICY rp0 ON db0 DURATION 1h REPLICATION 1
+
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:rp0
+cpu,host=server01 value=1 1434055562000000000
+cpu,host=server02 value=2 1434055562000000000
+mem,host=server01 value=3 1434055562000000000
+`
+
+// Server is a test HTTP server that records the requests made by the importer.
+// It answers /ping, /write and /query as InfluxDB does, keeping the line
+// protocol of each successful write, and its fields make requests fail or
+// answer differently, so imports can be tested end to end.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	attempts int
+	writes   []string
+	params   []url.Values
+	queries  []string
+	qparams  []url.Values
+	rps      map[string][]ServerRetentionPolicy
+
+	// WriteStatus, if set, returns the status code for the nth write request.
+	// Only writes answered with a 2xx status are recorded.
+	WriteStatus func(n int) int
+
+	// WriteBody, if set, returns the body to answer the nth write request
+	// with. An empty string keeps the default.
+	WriteBody func(n int) string
+
+	// AuthToken, if set, is the token every request must be authorized with.
+	AuthToken string
+
+	// RequiredHeaders, if set, must be present on every request.
+	RequiredHeaders map[string]string
+
+	// WriteDelay is how long write requests take to be answered.
+	WriteDelay time.Duration
+
+	// Drop, if set, makes the server close the connection without answering
+	// a request when it returns true for the path, as if it were down.
+	Drop func(path string) bool
+
+	// RejectLine, if set, makes a write fail with 400 Bad Request if it
+	// returns true for any of the lines written.
+	RejectLine func(line string) bool
+
+	// QueryError, if set, returns the error to report for a query command.
+	// An empty string means the query succeeds.
+	QueryError func(q string) string
+
+	// QueryResults, if set, returns the JSON results to answer a query
+	// command for database db with. An empty string answers with a single
+	// empty result.
+	QueryResults func(db, q string) string
+
+	// Version, if set, is the version the server reports when pinged.
+	Version string
+
+	// Schema makes the server keep the retention policies of each database
+	// as the meta store does. CREATE DATABASE creates its "default" retention
+	// policy, and creating a retention policy that exists, or altering one
+	// that doesn't, fails.
+	Schema bool
+}
+
+// NewServer returns a new instance of Server.
+func NewServer() *Server {
+	s := &Server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// NewTLSServer returns a new instance of Server using https with a
+// self-signed certificate.
+func NewTLSServer() *Server {
+	s := &Server{}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// URL returns the parsed URL of the server.
+func (s *Server) URL() url.URL {
+	u, _ := url.Parse(s.Server.URL)
+	return *u
+}
+
+// Writes returns the bodies of all write requests received.
+func (s *Server) Writes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.writes...)
+}
+
+// WriteParams returns the query parameters of all write requests received.
+func (s *Server) WriteParams() []url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]url.Values(nil), s.params...)
+}
+
+// Attempts returns the number of write requests received, successful or not.
+func (s *Server) Attempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts
+}
+
+// QueryParams returns the query parameters of all query requests received.
+func (s *Server) QueryParams() []url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]url.Values(nil), s.qparams...)
+}
+
+// Queries returns the commands of all query requests received.
+func (s *Server) Queries() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.queries...)
+}
+
+// ServerRetentionPolicy is a retention policy created on a Server.
+type ServerRetentionPolicy struct {
+	Name      string
+	Duration  time.Duration
+	ReplicaN  int
+	IsDefault bool
+}
+
+// execute applies the retention policy statements in q for Schema. It
+// returns the error to answer with, or an empty string.
+func (s *Server) execute(q string) string {
+	stmt, err := influxql.ParseStatement(q)
+	if err != nil {
+		return ""
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rps == nil {
+		s.rps = make(map[string][]ServerRetentionPolicy)
+	}
+	switch stmt := stmt.(type) {
+	case *influxql.CreateDatabaseStatement:
+		if _, ok := s.rps[stmt.Name]; !ok {
+			s.rps[stmt.Name] = []ServerRetentionPolicy{{Name: "default", ReplicaN: 1, IsDefault: true}}
+		}
+	case *influxql.CreateRetentionPolicyStatement:
+		rps := s.rps[stmt.Database]
+		for _, rp := range rps {
+			if rp.Name == stmt.Name {
+				return "retention policy already exists"
+			}
+		}
+		if stmt.Default {
+			for i := range rps {
+				rps[i].IsDefault = false
+			}
+		}
+		s.rps[stmt.Database] = append(rps, ServerRetentionPolicy{stmt.Name, stmt.Duration, stmt.Replication, stmt.Default})
+	case *influxql.AlterRetentionPolicyStatement:
+		rps := s.rps[stmt.Database]
+		for i := range rps {
+			if rps[i].Name != stmt.Name {
+				continue
+			}
+			if stmt.Duration != nil {
+				rps[i].Duration = *stmt.Duration
+			}
+			if stmt.Replication != nil {
+				rps[i].ReplicaN = *stmt.Replication
+			}
+			if stmt.Default {
+				for j := range rps {
+					rps[j].IsDefault = i == j
+				}
+			}
+			return ""
+		}
+		return "retention policy not found"
+	}
+	return ""
+}
+
+// RetentionPolicies returns the retention policies of database db.
+func (s *Server) RetentionPolicies(db string) []ServerRetentionPolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ServerRetentionPolicy(nil), s.rps[db]...)
+}
+
+// StoredResults answers the queries made by the exporter from the points
+// written to the server and, with Schema set, the retention policies it has,
+// so that it can be used as QueryResults to export what was imported.
+func (s *Server) StoredResults(db, q string) string {
+	stmt, err := influxql.ParseStatement(q)
+	if err != nil {
+		return ""
+	}
+
+	var rows []influxql.Row
+	switch stmt := stmt.(type) {
+	case *influxql.ShowRetentionPoliciesStatement:
+		row := influxql.Row{Columns: []string{"name", "duration", "replicaN", "default"}}
+		for _, rp := range s.RetentionPolicies(stmt.Database) {
+			row.Values = append(row.Values, []interface{}{rp.Name, rp.Duration.String(), rp.ReplicaN, rp.IsDefault})
+		}
+		rows = append(rows, row)
+	case *influxql.ShowMeasurementsStatement:
+		row := influxql.Row{Name: "measurements", Columns: []string{"name"}}
+		seen := make(map[string]bool)
+		for _, p := range s.points(db, "") {
+			if !seen[p.Name()] {
+				seen[p.Name()] = true
+				row.Values = append(row.Values, []interface{}{p.Name()})
+			}
+		}
+		rows = append(rows, row)
+	case *influxql.SelectStatement:
+		m := stmt.Sources[0].(*influxql.Measurement)
+		series := make(map[string]*influxql.Row)
+		var keys []string
+		fields := make(map[string]bool)
+		for _, p := range s.points(m.Database, m.RetentionPolicy) {
+			if p.Name() != m.Name {
+				continue
+			}
+			key := string(p.Key())
+			if series[key] == nil {
+				series[key] = &influxql.Row{Name: p.Name(), Tags: p.Tags()}
+				keys = append(keys, key)
+			}
+			series[key].Values = append(series[key].Values, []interface{}{p})
+			for name := range p.Fields() {
+				fields[name] = true
+			}
+		}
+		columns := []string{"time"}
+		for name := range fields {
+			columns = append(columns, name)
+		}
+		sort.Strings(columns[1:])
+		for _, key := range keys {
+			row := series[key]
+			var values [][]interface{}
+			for i, v := range row.Values {
+				if i < stmt.Offset || i >= stmt.Offset+stmt.Limit {
+					continue
+				}
+				p := v[0].(tsdb.Point)
+				value := []interface{}{p.Time().UTC().Format(time.RFC3339Nano)}
+				for _, name := range columns[1:] {
+					value = append(value, p.Fields()[name])
+				}
+				values = append(values, value)
+			}
+			if len(values) > 0 {
+				rows = append(rows, influxql.Row{Name: row.Name, Tags: row.Tags, Columns: columns, Values: values})
+			}
+		}
+	default:
+		return ""
+	}
+
+	b, _ := json.Marshal([]struct {
+		Series []influxql.Row `json:"series,omitempty"`
+	}{{rows}})
+	return string(b)
+}
+
+// points returns the points written to retention policy rp of database db,
+// in the order they were written. An empty rp matches every retention policy.
+func (s *Server) points(db, rp string) []tsdb.Point {
+	params := s.WriteParams()
+	var points []tsdb.Point
+	for i, body := range s.Writes() {
+		if params[i].Get("db") != db || (rp != "" && params[i].Get("rp") != rp) {
+			continue
+		}
+		p, _ := tsdb.ParsePointsWithPrecision([]byte(body), time.Now(), params[i].Get("precision"))
+		points = append(points, p...)
+	}
+	return points
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Drop != nil && s.Drop(r.URL.Path) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+	if s.AuthToken != "" && r.Header.Get("Authorization") != "Token "+s.AuthToken {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	for k, v := range s.RequiredHeaders {
+		if r.Header.Get(k) != v {
+			http.Error(w, "missing header "+k, http.StatusBadRequest)
+			return
+		}
+	}
+	switch r.URL.Path {
+	case "/ping":
+		if s.Version != "" {
+			w.Header().Set("X-Influxdb-Version", s.Version)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "/write":
+		body := r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body = gz
+		}
+		b, _ := ioutil.ReadAll(body)
+		time.Sleep(s.WriteDelay)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		status, n := http.StatusNoContent, s.attempts
+		if s.WriteStatus != nil {
+			status = s.WriteStatus(n)
+		}
+		s.attempts++
+		var resp string
+		if s.WriteBody != nil {
+			resp = s.WriteBody(n)
+		}
+		if s.RejectLine != nil {
+			for _, l := range strings.Split(string(b), "\n") {
+				if s.RejectLine(l) {
+					status = http.StatusBadRequest
+				}
+			}
+		}
+		if status/100 != 2 {
+			if resp == "" {
+				resp = "write failed"
+			}
+			http.Error(w, resp, status)
+			return
+		}
+		s.writes = append(s.writes, string(b))
+		s.params = append(s.params, r.URL.Query())
+		w.WriteHeader(status)
+		w.Write([]byte(resp))
+	case "/query":
+		q := r.URL.Query().Get("q")
+		s.mu.Lock()
+		s.queries = append(s.queries, q)
+		s.qparams = append(s.qparams, r.URL.Query())
+		s.mu.Unlock()
+		if s.QueryError != nil {
+			if msg := s.QueryError(q); msg != "" {
+				fmt.Fprintf(w, `{"results":[{"error":%q}]}`, msg)
+				return
+			}
+		}
+		if s.Schema {
+			if msg := s.execute(q); msg != "" {
+				fmt.Fprintf(w, `{"results":[{"error":%q}]}`, msg)
+				return
+			}
+		}
+		if s.QueryResults != nil {
+			if results := s.QueryResults(r.URL.Query().Get("db"), q); results != "" {
+				fmt.Fprintf(w, `{"results":%s}`, results)
+				return
+			}
+		}
+		w.Write([]byte(`{"results":[{}]}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Logger is a v8.Logger that records the messages it is given.
+type Logger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+// Printf records a formatted message.
+func (l *Logger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.msgs = append(l.msgs, fmt.Sprintf(format, v...))
+}
+
+// Messages returns the messages recorded so far.
+func (l *Logger) Messages() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.msgs...)
+}
+
+// MustWriteTempGzipFile writes gzipped content to a temporary file and returns its path.
+func MustWriteTempGzipFile(content string) string {
+	return MustWriteTempFile(MustGzip(content))
+}
+
+// MustGzip returns content compressed with gzip.
+func MustGzip(content string) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(content)); err != nil {
+		panic(err)
+	} else if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return buf.String()
+}
+
+// MustWriteTempFile writes content to a temporary file and returns its path.
+func MustWriteTempFile(content string) string {
+	f, err := ioutil.TempFile("", "influxdb-importer-")
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		panic(err)
+	}
+	return f.Name()
+}
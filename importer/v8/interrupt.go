@@ -0,0 +1,38 @@
+package v8
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ErrInterrupted is returned when an import with StopOnInterrupt set is
+// stopped by an interrupt or termination signal.
+var ErrInterrupted = errors.New("import interrupted")
+
+// handleInterrupt stops the import on the first SIGINT or SIGTERM, and
+// returns a function that stops handling them. Only the first signal is
+// caught, so a second one terminates the process as usual.
+func (v8 *V8) handleInterrupt() func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case sig := <-sigs:
+			signal.Stop(sigs)
+			v8.logger().Printf("Received %s, stopping once the lines already read are written\n", sig)
+			v8.abort(ErrInterrupted)
+		case <-stop:
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(stop)
+		<-stopped
+	}
+}
@@ -0,0 +1,43 @@
+package v8
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Jitter strategies for the delay before a write is retried.
+const (
+	// JitterFull waits for a random time up to the backoff.
+	JitterFull = ""
+
+	// JitterEqual waits for half the backoff and a random time up to the
+	// other half, so retries are spread out but never come early.
+	JitterEqual = "equal"
+
+	// JitterNone waits for the backoff exactly.
+	JitterNone = "none"
+)
+
+// validJitter returns true if j is one of the jitter strategies.
+func validJitter(j string) bool {
+	switch j {
+	case JitterFull, JitterEqual, JitterNone:
+		return true
+	}
+	return false
+}
+
+// retryDelay returns how long to wait before retrying after backoff, with
+// the configured RetryJitter applied.
+func (c *V8Config) retryDelay(backoff time.Duration) time.Duration {
+	if backoff <= 0 {
+		return backoff
+	}
+	switch c.RetryJitter {
+	case JitterNone:
+		return backoff
+	case JitterEqual:
+		return backoff/2 + time.Duration(rand.Int63n(int64(backoff-backoff/2)+1))
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
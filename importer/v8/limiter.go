@@ -0,0 +1,49 @@
+package v8
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// limiter restricts the rate at which points are written. It is shared by
+// all of the batch writers so that the limit applies to the import as a whole.
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration // time allowed per point
+	next     time.Time     // time at which the next write may start
+}
+
+// newLimiter returns a limiter allowing limit points per second. A limit <= 0
+// returns nil, which does not limit anything.
+func newLimiter(limit int) *limiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &limiter{interval: time.Second / time.Duration(limit)}
+}
+
+// wait blocks until n points may be written without exceeding the limit, or
+// until ctx is done. Writes that arrive after a pause start immediately, so
+// bursts are limited to a single batch.
+func (l *limiter) wait(ctx context.Context, n int) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(n) * l.interval)
+	l.mu.Unlock()
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
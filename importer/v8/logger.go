@@ -0,0 +1,29 @@
+package v8
+
+import "log"
+
+// Logger is the interface the importer writes its log messages through.
+// *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// stdLogger logs through the standard log package.
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) { log.Printf(format, v...) }
+
+// logger returns the configured Logger, or the standard logger if none is set.
+func (v8 *V8) logger() Logger {
+	if v8.config.Logger != nil {
+		return v8.config.Logger
+	}
+	return stdLogger{}
+}
+
+// logErrorf logs an error with a single command or batch, unless Quiet is set.
+func (v8 *V8) logErrorf(format string, v ...interface{}) {
+	if !v8.config.Quiet {
+		v8.logger().Printf(format, v...)
+	}
+}
@@ -0,0 +1,54 @@
+// Package metrics exports the progress of a v8 import as Prometheus metrics.
+// It is kept apart from the importer so that only programs that use it
+// depend on the Prometheus client.
+package metrics
+
+import (
+	"github.com/influxdb/influxdb/importer/v8"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a prometheus.Collector reporting the progress of an importer as
+// it runs. The counters are read from the importer's Counters each time they
+// are collected, so they keep counting across Reset.
+type Collector struct {
+	importer *v8.V8
+
+	inserts  *prometheus.Desc
+	failed   *prometheus.Desc
+	commands *prometheus.Desc
+	inFlight *prometheus.Desc
+}
+
+// NewCollector returns a Collector for importer. It should be registered
+// before the import starts, for example with prometheus.MustRegister.
+func NewCollector(importer *v8.V8) *Collector {
+	return &Collector{
+		importer: importer,
+		inserts: prometheus.NewDesc("influx_import_inserts_total",
+			"Number of inserts written.", nil, nil),
+		failed: prometheus.NewDesc("influx_import_failed_total",
+			"Number of inserts that failed to be written.", nil, nil),
+		commands: prometheus.NewDesc("influx_import_commands_total",
+			"Number of DDL commands executed, including any that failed.", nil, nil),
+		inFlight: prometheus.NewDesc("influx_import_batches_in_flight",
+			"Number of batches waiting to be written or being written.", nil, nil),
+	}
+}
+
+// Describe sends the descriptions of the metrics to ch.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.inserts
+	ch <- c.failed
+	ch <- c.commands
+	ch <- c.inFlight
+}
+
+// Collect sends the current values of the metrics to ch.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	n := c.importer.Counters()
+	ch <- prometheus.MustNewConstMetric(c.inserts, prometheus.CounterValue, float64(n.Inserts))
+	ch <- prometheus.MustNewConstMetric(c.failed, prometheus.CounterValue, float64(n.FailedInserts))
+	ch <- prometheus.MustNewConstMetric(c.commands, prometheus.CounterValue, float64(n.Commands))
+	ch <- prometheus.MustNewConstMetric(c.inFlight, prometheus.GaugeValue, float64(c.importer.InFlightBatches()))
+}
@@ -0,0 +1,95 @@
+package metrics_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/influxdb/influxdb/importer/v8"
+	"github.com/influxdb/influxdb/importer/v8/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const dump = `# DDL
+CREATE DATABASE db0
+
+# DML
+# CONTEXT-DATABASE:db0
+cpu,host=server01 value=1 1434055562000000000
+cpu,host=server02 value=2 1434055562000000000
+mem,host=server01 value=3 1434055562000000000
+`
+
+// Ensure that the collector reports the importer's totals, and that they keep
+// counting up after the importer is reset.
+func TestCollector(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/query":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"results":[{}]}`))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer s.Close()
+	u, _ := url.Parse(s.URL)
+
+	f, err := ioutil.TempFile("", "influxdb-metrics-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(dump)
+	f.Close()
+
+	i := v8.NewV8(v8.NewV8Config("", "", "", "", f.Name(), "test", *u, false, 1))
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(metrics.NewCollector(i)); err != nil {
+		t.Fatal(err)
+	}
+
+	for n := 1; n <= 2; n++ {
+		if err := i.Import(); err != nil {
+			t.Fatal(err)
+		}
+		exp := map[string]float64{
+			"influx_import_inserts_total":     float64(3 * n),
+			"influx_import_failed_total":      0,
+			"influx_import_commands_total":    float64(n),
+			"influx_import_batches_in_flight": 0,
+		}
+		if got := MustGather(t, reg); len(got) != len(exp) {
+			t.Fatalf("unexpected metrics: %v", got)
+		} else {
+			for name, v := range exp {
+				if got[name] != v {
+					t.Fatalf("import %d: unexpected %s: %v, expected %v", n, name, got[name], v)
+				}
+			}
+		}
+		i.Reset()
+	}
+}
+
+// MustGather scrapes reg and returns the value of each metric by name.
+func MustGather(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			if c := m.GetCounter(); c != nil {
+				values[f.GetName()] = c.GetValue()
+			} else if g := m.GetGauge(); g != nil {
+				values[f.GetName()] = g.GetValue()
+			}
+		}
+	}
+	return values
+}
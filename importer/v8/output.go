@@ -0,0 +1,103 @@
+package v8
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/influxdb/influxdb/client"
+)
+
+// output writes the commands and batches of an import to V8Config.Output as
+// an export, instead of sending them to a server. Commands go in the DDL
+// section, or in the DML section once lines have been written, where the
+// importer executes them again when the output is imported.
+type output struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+
+	ddlMarker, dmlMarker string
+	ddl, dml             bool // whether each marker has been written
+
+	// The context most recently written to the output.
+	database, retentionPolicy, precision string
+
+	// err is the first write that failed, after which nothing more is written.
+	err error
+}
+
+// newOutput returns an output writing to w, with the section markers of c.
+// Lines are read back in nanoseconds until a precision header is written.
+func newOutput(w io.Writer, c *V8Config) *output {
+	return &output{w: bufio.NewWriter(w), ddlMarker: c.ddlMarker(), dmlMarker: c.dmlMarker(), precision: "n"}
+}
+
+// writeCommand writes a DDL command.
+func (o *output) writeCommand(command string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !o.ddl && !o.dml {
+		o.printf("%s\n", o.ddlMarker)
+		o.ddl = true
+	}
+	o.printf("%s\n", command)
+	return o.err
+}
+
+// WriteLines writes lines under the context they are for, so that the output
+// imports them into the same database and retention policy. The response is
+// never retryable, as a failed write can't be undone.
+func (o *output) WriteLines(lines []string, database, retentionPolicy, precision, writeConsistency string) (*client.Response, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !o.dml {
+		if o.ddl {
+			o.printf("\n")
+		}
+		o.printf("%s\n", o.dmlMarker)
+		o.dml = true
+	}
+	if database != o.database {
+		o.printf("# CONTEXT-DATABASE:%s\n", database)
+		o.database = database
+	}
+	if retentionPolicy != o.retentionPolicy {
+		o.printf("# CONTEXT-RETENTION-POLICY:%s\n", retentionPolicy)
+		o.retentionPolicy = retentionPolicy
+	}
+	if p := parsePrecision(precision); p != o.precision {
+		o.printf("%s%s\n", contextPrecision, p)
+		o.precision = p
+	}
+	for _, l := range lines {
+		o.printf("%s\n", l)
+	}
+	if o.err != nil {
+		return &client.Response{}, fmt.Errorf("writing output: %s", o.err)
+	}
+	return nil, nil
+}
+
+// printf writes to the output, unless an earlier write failed.
+func (o *output) printf(format string, a ...interface{}) {
+	if o.err == nil {
+		_, o.err = fmt.Fprintf(o.w, format, a...)
+	}
+}
+
+// flush writes any buffered output. It does nothing on a nil output.
+func (o *output) flush() error {
+	if o == nil {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.err == nil {
+		o.err = o.w.Flush()
+	}
+	return o.err
+}
@@ -0,0 +1,21 @@
+package v8
+
+// WriteOverride is how the lines of a measurement are written, in place of
+// the configured write consistency and precision. Fields left empty aren't
+// overridden.
+type WriteOverride struct {
+	Consistency, Precision string
+}
+
+// writeOptions returns the write consistency and precision that line, read
+// in context c, is written with. An empty consistency is the configured one.
+func (v8 *V8) writeOptions(c lineContext, line string) (consistency, precision string) {
+	precision = v8.writePrecision(c)
+	if o, ok := v8.config.MeasurementOverrides[measurementName(line)]; ok {
+		consistency = o.Consistency
+		if o.Precision != "" {
+			precision = o.Precision
+		}
+	}
+	return consistency, precision
+}
@@ -0,0 +1,111 @@
+package v8
+
+import (
+	"context"
+	"sync"
+)
+
+// importParallel imports files through FileConcurrency readers, giving each
+// file a stream of its own. The DDL sections are executed one at a time, in
+// the order of files, while the DML is read in parallel. The first error
+// stops any more lines being read, and is returned once the readers finish.
+func (v8 *V8) importParallel(ctx context.Context, files []string) error {
+	// Unlike ctx, cancelling this doesn't stop writes being retried
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type job struct {
+		file string
+		turn *ddlTurn
+	}
+	jobs := make(chan job)
+
+	var (
+		mu                    sync.Mutex
+		err                   error
+		readers, accumulators sync.WaitGroup
+	)
+	n := v8.config.FileConcurrency
+	if n > len(files) {
+		n = len(files)
+	}
+	for i := 0; i < n; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for j := range jobs {
+				s := v8.startStream(&accumulators)
+				e := v8.importFile(readCtx, s, j.file, j.turn)
+				close(s.done)
+				if e != nil {
+					mu.Lock()
+					if err == nil {
+						err = e
+					}
+					mu.Unlock()
+					cancel()
+				}
+			}
+		}()
+	}
+
+	var turn *ddlTurn
+dispatch:
+	for _, file := range files {
+		turn = turn.next()
+		select {
+		case jobs <- job{file: file, turn: turn}:
+		case <-readCtx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	readers.Wait()
+	accumulators.Wait()
+	close(v8.batches)
+
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+// ddlTurn makes the DDL sections of files read in parallel execute one at a
+// time, in the order the files were given. Each file waits for the turn of
+// the file before it to be passed on before reading its DDL.
+type ddlTurn struct {
+	prev   <-chan struct{} // closed once the file before has passed its turn
+	passed chan struct{}
+	once   sync.Once
+}
+
+// next returns the turn for the file after the one t is for. A nil t
+// returns the turn for the first file, which doesn't wait.
+func (t *ddlTurn) next() *ddlTurn {
+	n := &ddlTurn{passed: make(chan struct{})}
+	if t != nil {
+		n.prev = t.passed
+	}
+	return n
+}
+
+// wait blocks until it is t's turn, or ctx is done. A nil t never waits.
+func (t *ddlTurn) wait(ctx context.Context) error {
+	if t == nil || t.prev == nil {
+		return nil
+	}
+	select {
+	case <-t.prev:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pass lets the next file take its turn. It may be called more than once.
+func (t *ddlTurn) pass() {
+	if t == nil {
+		return
+	}
+	t.once.Do(func() { close(t.passed) })
+}
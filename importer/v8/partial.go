@@ -0,0 +1,95 @@
+package v8
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/influxdb/influxdb/client"
+)
+
+// droppedRe matches the number of points a server reports dropping from a
+// partial write, as in "partial write: field type conflict: ... dropped=2".
+var droppedRe = regexp.MustCompile(`\bdropped=(\d+)`)
+
+// maxPartialWrites is the number of partial writes kept for the summary.
+const maxPartialWrites = 20
+
+// PartialWrite is a batch that the server wrote only some of the points of.
+type PartialWrite struct {
+	// Location is the range of lines the batch was read from.
+	Location string `json:"location"`
+
+	// Dropped is the number of points of the batch that weren't written.
+	Dropped int `json:"dropped"`
+
+	// Reason is the error the server gave.
+	Reason string `json:"reason"`
+}
+
+// partialWrite returns how many of the n points of a batch the server
+// dropped, and why, if the response to writing it reports a partial write:
+// the server either accepted the write with an error in the response, or
+// refused it with an error giving the number of points dropped. The rest
+// of the points were written. An accepted write whose error doesn't say how
+// many points were dropped has all of them counted, as it isn't known which
+// were kept.
+func partialWrite(resp *client.Response, err error, n int) (dropped int, reason string, ok bool) {
+	if err == nil && resp != nil && resp.Err != nil {
+		dropped, reason = n, strings.TrimSpace(resp.Err.Error())
+	} else if err != nil {
+		reason = serverError(err)
+	} else {
+		return 0, "", false
+	}
+
+	m := droppedRe.FindStringSubmatch(reason)
+	if m == nil {
+		return dropped, reason, err == nil
+	}
+	if d, e := strconv.Atoi(m[1]); e == nil && d < n {
+		dropped = d
+	} else {
+		dropped = n
+	}
+	return dropped, reason, true
+}
+
+// serverError returns the message of err, taken from the JSON object the
+// server answers errors with, if it is one.
+func serverError(err error) string {
+	msg := strings.TrimSpace(err.Error())
+	var body struct {
+		Err string `json:"error"`
+	}
+	if json.Unmarshal([]byte(msg), &body) == nil && body.Err != "" {
+		return body.Err
+	}
+	return msg
+}
+
+// partialBatch records that the server wrote b except for dropped of its
+// points. The response doesn't say which ones they were, so the failed lines
+// file isn't given any of them, and the batch isn't counted in the bytes
+// written or the per-measurement totals.
+func (v8 *V8) partialBatch(b lineBatch, dropped int, reason string) batchResult {
+	written := len(b.lines) - dropped
+	v8.logErrorf("partial write of batch %s, %d of %d points dropped: %s\n", b.location(), dropped, len(b.lines), reason)
+	atomic.AddInt64(&v8.totalInserts, int64(written))
+	atomic.AddInt64(&v8.allInserts, int64(written))
+	atomic.AddInt64(&v8.partialBatches, 1)
+
+	v8.measurementsMu.Lock()
+	if len(v8.partialWrites) < maxPartialWrites {
+		v8.partialWrites = append(v8.partialWrites, PartialWrite{Location: b.location(), Dropped: dropped, Reason: reason})
+	}
+	v8.measurementsMu.Unlock()
+
+	failed := atomic.AddInt64(&v8.failedInserts, int64(dropped))
+	atomic.AddInt64(&v8.allFailedInserts, int64(dropped))
+	v8.checkFailures(b, errors.New(reason), failed)
+	return batchResult{inserts: written, failedInserts: dropped}
+}
@@ -0,0 +1,231 @@
+package v8
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressWindow is the number of ProgressIntervals the logged insert rate is
+// measured over.
+const progressWindow = 5
+
+const (
+	// etaHalfLife is how long it takes a change in the read rate to make up
+	// half of the rate that the remaining time is estimated from.
+	etaHalfLife = 30 * time.Second
+
+	// etaWarmup is how long the input is read for before the remaining time
+	// is estimated, as until then the rate is mostly start up noise.
+	etaWarmup = 10 * time.Second
+
+	// etaSample is the shortest time the rate is measured over, so that
+	// progress reported after every batch doesn't make it jumpy.
+	etaSample = time.Second
+)
+
+// ProgressReport is passed to the Progress callback as an import runs.
+type ProgressReport struct {
+	TotalInserts  int
+	FailedInserts int
+	TotalCommands int
+
+	// BytesRead is the number of bytes read from the input files so far.
+	// Compressed files are measured before decompression.
+	BytesRead int64
+
+	// TotalBytes is the combined size of the input files, or zero if it
+	// isn't known, such as when reading from standard input.
+	TotalBytes int64
+
+	// EstimatedRemaining is how much longer reading the input is expected
+	// to take, at the rate it has recently been read. It is zero if
+	// TotalBytes isn't known, and until the rate has had time to settle.
+	EstimatedRemaining time.Duration
+}
+
+// Percent returns how much of the input has been read, from 0 to 100.
+// It returns -1 if the size of the input isn't known.
+func (p ProgressReport) Percent() float64 {
+	if p.TotalBytes <= 0 {
+		return -1
+	}
+	return float64(p.BytesRead) / float64(p.TotalBytes) * 100
+}
+
+// progress reports the running totals to the Progress callback, if one is set.
+// Calls are serialized so the callback doesn't need its own locking.
+func (v8 *V8) progress() {
+	if v8.config.Progress == nil {
+		return
+	}
+	v8.progressMu.Lock()
+	defer v8.progressMu.Unlock()
+	read := atomic.LoadInt64(&v8.bytesRead)
+	v8.config.Progress(ProgressReport{
+		TotalInserts:       int(atomic.LoadInt64(&v8.totalInserts)),
+		FailedInserts:      int(atomic.LoadInt64(&v8.failedInserts)),
+		TotalCommands:      int(atomic.LoadInt64(&v8.totalCommands)),
+		BytesRead:          read,
+		TotalBytes:         v8.totalBytes,
+		EstimatedRemaining: v8.eta.remaining(time.Now(), read),
+	})
+}
+
+// startProgressLog logs a progress line every ProgressInterval, if it is set,
+// until the returned function is called.
+func (v8 *V8) startProgressLog() (stop func()) {
+	if v8.config.ProgressInterval <= 0 {
+		return func() {}
+	}
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(v8.config.ProgressInterval)
+		defer t.Stop()
+
+		w := newRateWindow(progressWindow)
+		w.add(time.Now(), atomic.LoadInt64(&v8.totalInserts))
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-t.C:
+				inserts := atomic.LoadInt64(&v8.totalInserts)
+				w.add(now, inserts)
+				msg := fmt.Sprintf("Progress: %d inserts, %.1f inserts/sec, %d failed",
+					inserts, w.rate(), atomic.LoadInt64(&v8.failedInserts))
+				if v8.totalBytes > 0 {
+					read := atomic.LoadInt64(&v8.bytesRead)
+					msg += fmt.Sprintf(", %.1f%% read", float64(read)/float64(v8.totalBytes)*100)
+					if eta := v8.eta.remaining(now, read); eta > 0 {
+						msg += fmt.Sprintf(", about %s left", eta)
+					}
+				}
+				v8.logger().Printf("%s\n", msg)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// rateWindow holds the most recent samples of a counter, so that its rate can
+// be measured over a rolling window rather than since the start.
+type rateWindow struct {
+	times  []time.Time
+	counts []int64
+	size   int
+}
+
+// newRateWindow returns a rateWindow measuring the rate over the last n samples.
+func newRateWindow(n int) *rateWindow {
+	return &rateWindow{size: n + 1}
+}
+
+// add records that the counter was n at time t.
+func (w *rateWindow) add(t time.Time, n int64) {
+	w.times = append(w.times, t)
+	w.counts = append(w.counts, n)
+	if len(w.times) > w.size {
+		w.times, w.counts = w.times[1:], w.counts[1:]
+	}
+}
+
+// rate returns the average increase per second across the window.
+func (w *rateWindow) rate() float64 {
+	if len(w.times) < 2 {
+		return 0
+	}
+	first, last := 0, len(w.times)-1
+	d := w.times[last].Sub(w.times[first]).Seconds()
+	if d <= 0 {
+		return 0
+	}
+	return float64(w.counts[last]-w.counts[first]) / d
+}
+
+// etaEstimator estimates how long reading the input will take from an
+// exponentially weighted moving average of the rate it is read at.
+type etaEstimator struct {
+	mu      sync.Mutex
+	total   int64 // size of the input
+	start   time.Time
+	last    time.Time // when the rate was last measured
+	lastN   int64     // bytes read by then
+	rate    float64   // bytes per second
+	sampled bool      // whether the rate has been measured
+}
+
+// newETAEstimator returns an etaEstimator for an input of total bytes that
+// started being read at start.
+func newETAEstimator(start time.Time, total int64) *etaEstimator {
+	return &etaEstimator{total: total, start: start, last: start}
+}
+
+// remaining records that n bytes of the input had been read at now, and
+// returns the estimated time left to read the rest. It returns zero if there
+// is no estimate, as the size of the input isn't known, the import started
+// too recently, or nothing is being read. It does nothing on a nil estimator.
+func (e *etaEstimator) remaining(now time.Time, n int64) time.Duration {
+	if e == nil || e.total <= 0 {
+		return 0
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if d := now.Sub(e.last); d >= etaSample {
+		r := float64(n-e.lastN) / d.Seconds()
+		if !e.sampled {
+			e.rate, e.sampled = r, true
+		} else {
+			// Weigh the new rate by how long it was measured over, so that
+			// samples taken at uneven intervals count fairly
+			e.rate += (1 - math.Exp2(-d.Seconds()/etaHalfLife.Seconds())) * (r - e.rate)
+		}
+		e.last, e.lastN = now, n
+	}
+	if now.Sub(e.start) < etaWarmup || e.rate <= 0 || n >= e.total {
+		return 0
+	}
+	secs := float64(e.total-n) / e.rate
+	if secs > math.MaxInt64/float64(time.Second) {
+		return 0
+	}
+	return time.Duration(secs * float64(time.Second)).Round(time.Second)
+}
+
+// totalSize returns the combined size of files, or zero if any of them
+// can't be measured.
+func totalSize(files []string) int64 {
+	var n int64
+	for _, file := range files {
+		if file == "-" {
+			return 0
+		}
+		fi, err := os.Stat(file)
+		if err != nil {
+			return 0
+		}
+		n += fi.Size()
+	}
+	return n
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
@@ -0,0 +1,134 @@
+package v8
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/influxdb/influxdb/client"
+)
+
+// replica is a further server that every batch and DDL command is also sent
+// to. Its totals are kept apart from the main server's.
+type replica struct {
+	client *client.Client
+	url    string // without any credentials, for logs and the summary
+
+	totalInserts, failedInserts, failedBatches int64
+	failedCommands                             int64
+}
+
+// summary returns the totals for the replica.
+func (r *replica) summary() TargetSummary {
+	return TargetSummary{
+		URL:            r.url,
+		TotalInserts:   int(atomic.LoadInt64(&r.totalInserts)),
+		FailedInserts:  int(atomic.LoadInt64(&r.failedInserts)),
+		FailedBatches:  int(atomic.LoadInt64(&r.failedBatches)),
+		FailedCommands: int(atomic.LoadInt64(&r.failedCommands)),
+	}
+}
+
+// reset zeroes the replica's totals.
+func (r *replica) reset() {
+	atomic.StoreInt64(&r.totalInserts, 0)
+	atomic.StoreInt64(&r.failedInserts, 0)
+	atomic.StoreInt64(&r.failedBatches, 0)
+	atomic.StoreInt64(&r.failedCommands, 0)
+}
+
+// connectReplicas creates a client for each of the ReplicaURLs, unless they
+// were kept by Reset, and checks that every replica can be reached.
+func (v8 *V8) connectReplicas() error {
+	if v8.replicas == nil {
+		for _, u := range v8.config.ReplicaURLs {
+			cl, err := v8.newClient(u)
+			if err != nil {
+				return &ConnectError{Addr: u.Host, Replica: true, Err: err,
+					msg: fmt.Sprintf("could not create client for replica %s: %s", u.Host, err)}
+			}
+			u.User = nil
+			v8.replicas = append(v8.replicas, &replica{client: cl, url: u.String()})
+		}
+	}
+	if v8.config.SkipPing {
+		return nil
+	}
+	for _, r := range v8.replicas {
+		if _, _, err := r.client.Ping(); err != nil {
+			return &ConnectError{Addr: r.url, Replica: true, Err: err, msg: fmt.Sprintf("failed to connect to replica %s", r.url)}
+		}
+	}
+	return nil
+}
+
+// writeReplicas starts writing b to every replica, and returns a function
+// that waits for the writes to finish.
+func (v8 *V8) writeReplicas(ctx context.Context, b lineBatch) (wait func()) {
+	var wg sync.WaitGroup
+	if !v8.config.DryRun {
+		for _, r := range v8.replicas {
+			wg.Add(1)
+			go func(r *replica) {
+				defer wg.Done()
+				v8.writeReplica(ctx, r, b)
+			}(r)
+		}
+	}
+	return wg.Wait
+}
+
+// writeReplica writes b to r, retrying network and server errors in the same
+// way as writes to the main server, and records the outcome.
+func (v8 *V8) writeReplica(ctx context.Context, r *replica, b lineBatch) {
+	backoff := v8.config.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := r.client.WriteLines(v8.body(b), b.database, b.retentionPolicy, b.precision, v8.consistency(b, attempt))
+		if err == nil || !retryable(resp) || attempt >= v8.config.MaxRetries || ctx.Err() != nil {
+			v8.replicaWritten(r, b, resp, err)
+			return
+		}
+		select {
+		case <-time.After(v8.config.retryDelay(backoff)):
+		case <-ctx.Done():
+		}
+		backoff *= 2
+	}
+}
+
+// replicaWritten records the outcome of writing b to r. As with the main
+// server, the points a partial write dropped are failed and the rest written.
+func (v8 *V8) replicaWritten(r *replica, b lineBatch, resp *client.Response, err error) {
+	if dropped, reason, ok := partialWrite(resp, err, len(b.lines)); ok {
+		v8.logErrorf("partial write of batch %s to replica %s, %d of %d points dropped: %s\n", b.location(), r.url, dropped, len(b.lines), reason)
+		atomic.AddInt64(&r.totalInserts, int64(len(b.lines)-dropped))
+		atomic.AddInt64(&r.failedInserts, int64(dropped))
+		return
+	} else if err == nil {
+		atomic.AddInt64(&r.totalInserts, int64(len(b.lines)))
+		return
+	}
+	v8.logErrorf("error writing batch %s to replica %s: %s\n", b.location(), r.url, v8.timeoutError(err, true))
+	atomic.AddInt64(&r.failedInserts, int64(len(b.lines)))
+	atomic.AddInt64(&r.failedBatches, 1)
+}
+
+// executeReplicas executes a DDL command on every replica. Failures are logged
+// and counted against the replica, but don't affect the import.
+func (v8 *V8) executeReplicas(command, database string) {
+	for _, r := range v8.replicas {
+		response, err := r.client.Query(client.Query{Command: command, Database: database})
+		if err == nil {
+			err = response.Error()
+		}
+		if err != nil {
+			v8.logErrorf("error: %s on replica %s: %s\n", command, r.url, v8.timeoutError(err, false))
+			atomic.AddInt64(&r.failedCommands, 1)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package v8
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// lineTimestamp returns the timestamp at the end of a line protocol line.
+// It returns false if the line has none and the server assigns it.
+func lineTimestamp(line string) (int64, bool) {
+	i := strings.LastIndexByte(line, ' ')
+	if i < 0 {
+		return 0, false
+	}
+	ts, err := strconv.ParseInt(line[i+1:], 10, 64)
+	return ts, err == nil
+}
+
+// sortLines returns a copy of lines sorted by timestamp. Lines without a
+// timestamp come last, in the order they were read.
+func sortLines(lines []string) []string {
+	s := timestampSorter{lines: make([]string, len(lines)), ts: make([]int64, len(lines)), ok: make([]bool, len(lines))}
+	copy(s.lines, lines)
+	for i, l := range lines {
+		s.ts[i], s.ok[i] = lineTimestamp(l)
+	}
+	sort.Stable(s)
+	return s.lines
+}
+
+// timestampSorter sorts lines by their parsed timestamps.
+type timestampSorter struct {
+	lines []string
+	ts    []int64
+	ok    []bool
+}
+
+func (s timestampSorter) Len() int { return len(s.lines) }
+
+func (s timestampSorter) Less(i, j int) bool {
+	if s.ok[i] != s.ok[j] {
+		return s.ok[i]
+	}
+	return s.ok[i] && s.ts[i] < s.ts[j]
+}
+
+func (s timestampSorter) Swap(i, j int) {
+	s.lines[i], s.lines[j] = s.lines[j], s.lines[i]
+	s.ts[i], s.ts[j] = s.ts[j], s.ts[i]
+	s.ok[i], s.ok[j] = s.ok[j], s.ok[i]
+}
@@ -0,0 +1,131 @@
+package v8
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// isURL returns true if file is an http or https URL.
+func isURL(file string) bool {
+	return strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://")
+}
+
+// openURL starts downloading rawurl and returns the response body. The
+// request is cancelled if ctx is done before the body has been read. If
+// resumes is greater than zero, a download that drops is continued from
+// where it stopped with a range request, up to that many times.
+func openURL(ctx context.Context, rawurl string, resumes int, l Logger) (io.ReadCloser, error) {
+	var header http.Header
+	if resumes > 0 {
+		header = identity()
+	}
+	resp, err := fetch(ctx, rawurl, header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching %s: %s", displayName(rawurl), resp.Status)
+	}
+	if resumes <= 0 {
+		return resp.Body, nil
+	}
+	r := &resumingReader{ctx: ctx, url: rawurl, body: resp.Body, resumes: resumes, logger: l}
+	// Only resume from the same version of the file, so that a file replaced
+	// during the download isn't stitched together from both
+	if r.validator = resp.Header.Get("ETag"); r.validator == "" {
+		r.validator = resp.Header.Get("Last-Modified")
+	}
+	return r, nil
+}
+
+// fetch makes a GET request for rawurl with the given headers.
+func fetch(ctx context.Context, rawurl string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %s: %s", displayName(rawurl), err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		// Leave out the full URL the error would otherwise include
+		if e, ok := err.(*url.Error); ok {
+			err = e.Err
+		}
+		return nil, fmt.Errorf("fetching %s: %s", displayName(rawurl), err)
+	}
+	return resp, nil
+}
+
+// identity returns the headers asking for a download as it is stored. A
+// range counts bytes of the body as sent, so a body the client decompressed
+// itself would resume from the wrong place. Gzipped content is recognised
+// and decompressed later instead.
+func identity() http.Header {
+	return http.Header{"Accept-Encoding": {"identity"}}
+}
+
+// resumingReader reads the body of a download, and if the connection drops
+// requests the rest of it with a Range header.
+type resumingReader struct {
+	ctx       context.Context
+	url       string
+	body      io.ReadCloser
+	read      int64  // bytes read so far
+	resumes   int    // resumes left
+	validator string // ETag or Last-Modified of the first response
+	logger    Logger
+}
+
+// Read reads from the body, resuming the download if a read fails. The
+// error of the failed read is returned if it can't be resumed.
+func (r *resumingReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.body.Read(p)
+		r.read += int64(n)
+		if err == nil || err == io.EOF || r.resumes <= 0 || r.ctx.Err() != nil {
+			return n, err
+		}
+		r.resumes--
+		r.logger.Printf("Download of %s dropped after %d bytes, resuming: %s\n", displayName(r.url), r.read, err)
+		if e := r.resume(); e != nil {
+			r.logger.Printf("error resuming download of %s: %s\n", displayName(r.url), e)
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// resume replaces the body with the rest of the download.
+func (r *resumingReader) resume() error {
+	header := identity()
+	header.Set("Range", fmt.Sprintf("bytes=%d-", r.read))
+	if r.validator != "" {
+		header.Set("If-Range", r.validator)
+	}
+	resp, err := fetch(r.ctx, r.url, header)
+	if err != nil {
+		return err
+	}
+	// A full response would repeat what has been read
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return fmt.Errorf("%s, expected a range of the file", resp.Status)
+	}
+	r.body.Close()
+	r.body = resp.Body
+	return nil
+}
+
+// Close closes the body being read.
+func (r *resumingReader) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,360 @@
+package v8
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrPartialImport is returned, wrapped with the number of failures, when an
+// import completes but some of its commands or inserts failed.
+var ErrPartialImport = errors.New("import completed with failures")
+
+// ImportSummary holds the totals for an import.
+type ImportSummary struct {
+	TotalCommands  int `json:"totalCommands"`
+	FailedCommands int `json:"failedCommands"`
+	TotalInserts   int `json:"totalInserts"`
+	FailedInserts  int `json:"failedInserts"`
+	FailedBatches  int `json:"failedBatches"`
+
+	// Skipped is the number of lines left out by the measurement filters,
+	// by SampleRate or by Transform.
+	Skipped int `json:"skipped"`
+
+	// Rejected is the number of lines found to be invalid by ValidateLines,
+	// or with a field that FieldTypeHints couldn't coerce.
+	Rejected int `json:"rejected"`
+
+	// Duplicates is the number of repeated lines dropped by Dedup.
+	Duplicates int `json:"duplicates"`
+
+	// Fixed is the number of lines whose escaping was fixed by FixEscaping.
+	Fixed int `json:"fixed"`
+
+	// Blank is the number of empty or whitespace-only lines that were skipped.
+	Blank int `json:"blank"`
+
+	// Measurements is the number of inserts written to each measurement.
+	// Inserts written before a checkpoint that was resumed, and those of
+	// batches that were only partly written, aren't included.
+	Measurements map[string]int `json:"measurements"`
+
+	// Failures holds up to SampleFailures of the lines that failed to be
+	// written, in the order they failed.
+	Failures []FailureSample `json:"failures,omitempty"`
+
+	// PartialBatches is the number of batches the server wrote only some
+	// of the points of, whose dropped points are counted as failed inserts.
+	// PartialWrites holds the first of them, with the reasons the server gave.
+	PartialBatches int            `json:"partialBatches"`
+	PartialWrites  []PartialWrite `json:"partialWrites,omitempty"`
+
+	// Replicas holds the totals for each of the ReplicaURLs, in order.
+	Replicas []TargetSummary `json:"replicas,omitempty"`
+
+	// StartedAt is when the import started, and FinishedAt when it finished,
+	// which is the zero time while it is still running.
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+
+	// Duration is how long the import took, or has taken so far if it is
+	// still running. It is encoded in nanoseconds.
+	Duration time.Duration `json:"duration"`
+
+	// BytesRead is the number of bytes read from the input files.
+	// Compressed files are measured before decompression.
+	BytesRead int64 `json:"bytesRead"`
+
+	// Completed is true if every input file was read to the end. It is
+	// false if the import stopped early, such as at a truncated or corrupt
+	// file or because it was cancelled, and while it is still running. Lines
+	// that failed to be written don't make it false.
+	Completed bool `json:"completed"`
+
+	// BytesRemaining is the number of bytes of input that weren't read, or
+	// -1 if that isn't known, because the size of the input can't be
+	// measured or some of it was compressed. It is approximate, as input is
+	// read ahead of the lines being imported.
+	BytesRemaining int64 `json:"bytesRemaining"`
+
+	// BytesWritten is the size of the batches that were written in full, as
+	// line protocol before any compression of the requests.
+	BytesWritten int64 `json:"bytesWritten"`
+
+	// Throughput is BytesWritten over the Duration, in megabytes (one
+	// million bytes) per second.
+	Throughput float64 `json:"throughputMBps"`
+}
+
+// maxSampleLineBytes is the length that lines in failure samples are truncated to.
+const maxSampleLineBytes = 200
+
+// FailureSample is a line that failed to be written.
+type FailureSample struct {
+	// Location is the file and line number the line was read from.
+	Location string `json:"location"`
+
+	// Line is the line, truncated if it is long.
+	Line string `json:"line"`
+
+	// Error is the error that the line's batch failed with.
+	Error string `json:"error"`
+}
+
+// TargetSummary holds the totals for one of the servers written to.
+type TargetSummary struct {
+	URL            string `json:"url"`
+	TotalInserts   int    `json:"totalInserts"`
+	FailedInserts  int    `json:"failedInserts"`
+	FailedBatches  int    `json:"failedBatches"`
+	FailedCommands int    `json:"failedCommands"`
+}
+
+// Summary returns the totals for the most recent import. It may be called
+// while an import is running.
+func (v8 *V8) Summary() ImportSummary {
+	v8.mu.Lock()
+	var d time.Duration
+	if !v8.end.IsZero() {
+		d = v8.end.Sub(v8.start)
+	} else if !v8.start.IsZero() {
+		d = time.Since(v8.start)
+	}
+	startedAt, finishedAt, completed := v8.start, v8.end, v8.completed
+	started := !startedAt.IsZero()
+	v8.mu.Unlock()
+
+	v8.measurementsMu.Lock()
+	var measurements map[string]int
+	if len(v8.measurements) > 0 {
+		measurements = make(map[string]int, len(v8.measurements))
+		for name, n := range v8.measurements {
+			measurements[name] = n
+		}
+	}
+	failures := append([]FailureSample(nil), v8.failureSamples...)
+	partialWrites := append([]PartialWrite(nil), v8.partialWrites...)
+	v8.measurementsMu.Unlock()
+
+	var replicas []TargetSummary
+	for _, r := range v8.replicas {
+		replicas = append(replicas, r.summary())
+	}
+
+	written := atomic.LoadInt64(&v8.bytesWritten)
+	var throughput float64
+	if d > 0 {
+		throughput = float64(written) / 1e6 / d.Seconds()
+	}
+
+	read := atomic.LoadInt64(&v8.bytesRead)
+	var remaining int64
+	if started && !completed {
+		remaining = -1
+		if v8.totalBytes > 0 && atomic.LoadInt32(&v8.compressedInput) == 0 {
+			if remaining = v8.totalBytes - read; remaining < 0 {
+				remaining = 0
+			}
+		}
+	}
+
+	return ImportSummary{
+		TotalCommands:  int(atomic.LoadInt64(&v8.totalCommands)),
+		FailedCommands: int(atomic.LoadInt64(&v8.failedCommands)),
+		TotalInserts:   int(atomic.LoadInt64(&v8.totalInserts)),
+		FailedInserts:  int(atomic.LoadInt64(&v8.failedInserts)),
+		FailedBatches:  int(atomic.LoadInt64(&v8.failedBatches)),
+		Skipped:        int(atomic.LoadInt64(&v8.skippedInserts)),
+		Rejected:       int(atomic.LoadInt64(&v8.rejectedInserts)),
+		Duplicates:     int(atomic.LoadInt64(&v8.duplicateInserts)),
+		Fixed:          int(atomic.LoadInt64(&v8.fixedLines)),
+		Blank:          int(atomic.LoadInt64(&v8.blankLines)),
+		Measurements:   measurements,
+		Failures:       failures,
+		PartialBatches: int(atomic.LoadInt64(&v8.partialBatches)),
+		PartialWrites:  partialWrites,
+		Replicas:       replicas,
+		StartedAt:      startedAt,
+		FinishedAt:     finishedAt,
+		Duration:       d,
+		BytesRead:      read,
+		Completed:      completed,
+		BytesRemaining: remaining,
+		BytesWritten:   written,
+		Throughput:     throughput,
+	}
+}
+
+// InFlightBatches returns the number of batches that have been handed to the
+// writers but not yet written or failed. It may be called while an import is
+// running, and a value that stays up while no inserts complete suggests the
+// import has stalled.
+func (v8 *V8) InFlightBatches() int {
+	return int(atomic.LoadInt64(&v8.inFlightBatches))
+}
+
+// Counters holds running totals of the inserts and commands of every import
+// the importer has run. Unlike the totals in ImportSummary they are never
+// reset, so they only go up, as a monitoring system expects of a counter.
+type Counters struct {
+	Inserts       int64
+	FailedInserts int64
+	Commands      int64
+}
+
+// Counters returns the totals across every import, including those before
+// the last Reset. It may be called while an import is running.
+func (v8 *V8) Counters() Counters {
+	return Counters{
+		Inserts:       atomic.LoadInt64(&v8.allInserts),
+		FailedInserts: atomic.LoadInt64(&v8.allFailedInserts),
+		Commands:      atomic.LoadInt64(&v8.allCommands),
+	}
+}
+
+// countMeasurements adds lines, which have been written, to the per-measurement totals.
+func (v8 *V8) countMeasurements(lines []string) {
+	v8.measurementsMu.Lock()
+	defer v8.measurementsMu.Unlock()
+	if v8.measurements == nil {
+		v8.measurements = make(map[string]int)
+	}
+	for _, l := range lines {
+		v8.measurements[measurementName(l)]++
+	}
+}
+
+// sampleFailures keeps the lines of b, which failed with err, until
+// SampleFailures lines have been kept.
+func (v8 *V8) sampleFailures(b lineBatch, err error) {
+	v8.measurementsMu.Lock()
+	defer v8.measurementsMu.Unlock()
+	for i, l := range b.lines {
+		if len(v8.failureSamples) >= v8.config.SampleFailures {
+			return
+		}
+		if len(l) > maxSampleLineBytes {
+			// Cut at the start of a character, so the sample stays valid UTF-8
+			n := maxSampleLineBytes
+			for n > 0 && !utf8.RuneStart(l[n]) {
+				n--
+			}
+			l = l[:n] + "..."
+		}
+		v8.failureSamples = append(v8.failureSamples, FailureSample{
+			Location: fmt.Sprintf("%s:%d", displayName(b.file), b.nums[i]),
+			Line:     l,
+			Error:    strings.TrimSpace(err.Error()),
+		})
+	}
+}
+
+// partialImportError returns an error wrapping ErrPartialImport if any
+// commands or inserts failed, or any lines were rejected.
+func (v8 *V8) partialImportError() error {
+	s := v8.Summary()
+	if s.FailedCommands == 0 && s.FailedInserts == 0 && s.Rejected == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %d commands failed, %d inserts failed, %d inserts rejected",
+		ErrPartialImport, s.FailedCommands, s.FailedInserts, s.Rejected)
+}
+
+// stopClock records the end of the import, unless it has already been recorded.
+func (v8 *V8) stopClock() {
+	v8.mu.Lock()
+	defer v8.mu.Unlock()
+	if v8.end.IsZero() {
+		v8.end = time.Now()
+	}
+}
+
+// printSummary reports the totals at the end of an import, either as log
+// lines or, if JSONSummary is set, as a JSON object on standard output.
+func (v8 *V8) printSummary() {
+	s := v8.Summary()
+	if v8.config.JSONSummary {
+		b, err := json.Marshal(s)
+		if err != nil {
+			v8.logger().Printf("error encoding summary: %s\n", err)
+			return
+		}
+		fmt.Println(string(b))
+		return
+	}
+
+	if s.TotalInserts == 0 && s.FailedInserts == 0 && s.Skipped == 0 && s.Rejected == 0 && s.FailedCommands == 0 {
+		return
+	}
+	l := v8.logger()
+	if v8.config.DryRun {
+		l.Printf("Dry run, nothing was written\n")
+	}
+	if !s.Completed {
+		if s.BytesRemaining >= 0 {
+			l.Printf("Stopped before the end of the input, %d bytes were not read\n", s.BytesRemaining)
+		} else {
+			l.Printf("Stopped before the end of the input\n")
+		}
+	}
+	l.Printf("Processed %d commands\n", s.TotalCommands)
+	if s.FailedCommands > 0 {
+		l.Printf("Failed %d commands\n", s.FailedCommands)
+	}
+	l.Printf("Processed %d inserts\n", s.TotalInserts)
+	if s.BytesWritten > 0 {
+		l.Printf("Wrote %d bytes, %.2f MB/s\n", s.BytesWritten, s.Throughput)
+	}
+	l.Printf("Failed %d inserts\n", s.FailedInserts)
+	for _, f := range s.Failures {
+		l.Printf("Failed line %s: %s: %s\n", f.Location, f.Line, f.Error)
+	}
+	l.Printf("Failed %d batches\n", s.FailedBatches)
+	if s.PartialBatches > 0 {
+		l.Printf("Partially wrote %d batches\n", s.PartialBatches)
+		for _, p := range s.PartialWrites {
+			l.Printf("Partial write %s: %d dropped: %s\n", p.Location, p.Dropped, p.Reason)
+		}
+	}
+	if s.Skipped > 0 {
+		l.Printf("Skipped %d inserts\n", s.Skipped)
+	}
+	if s.Rejected > 0 {
+		l.Printf("Rejected %d inserts\n", s.Rejected)
+	}
+	if s.Duplicates > 0 {
+		l.Printf("Dropped %d duplicate inserts\n", s.Duplicates)
+	}
+	if s.Fixed > 0 {
+		l.Printf("Fixed the escaping of %d lines\n", s.Fixed)
+	}
+	if s.Blank > 0 {
+		l.Printf("Skipped %d blank lines\n", s.Blank)
+	}
+	for _, r := range s.Replicas {
+		l.Printf("Replica %s: processed %d inserts, failed %d inserts, failed %d batches\n",
+			r.URL, r.TotalInserts, r.FailedInserts, r.FailedBatches)
+		if r.FailedCommands > 0 {
+			l.Printf("Replica %s: failed %d commands\n", r.URL, r.FailedCommands)
+		}
+	}
+	// Timed even for a dry run, to estimate how long the real import will take
+	l.Printf("Started at %s, finished at %s, took %s\n",
+		s.StartedAt.Format(time.RFC3339), s.FinishedAt.Format(time.RFC3339), s.Duration)
+	if v8.config.MeasurementSummary {
+		names := make([]string, 0, len(s.Measurements))
+		for name := range s.Measurements {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			l.Printf("Processed %d inserts into %s\n", s.Measurements[name], name)
+		}
+	}
+}
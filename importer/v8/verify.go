@@ -0,0 +1,77 @@
+package v8
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// measurementDigest is the number of points in a measurement and a checksum
+// of them that doesn't depend on the order they were queried in.
+type measurementDigest struct {
+	points   int
+	checksum uint64
+}
+
+// Verify compares databases src and dst on the server, such as a database
+// and a copy of it that was exported and imported again. Every measurement
+// passing the measurement filter must have the same number of points in
+// each retention policy of src, and the points must be identical once
+// formatted as line protocol. It returns an error describing the first
+// difference found.
+func (e *Exporter) Verify(src, dst string) error {
+	ctx := context.Background()
+	rps, err := e.retentionPolicies(src)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]struct{})
+	for _, db := range []string{src, dst} {
+		measurements, err := e.measurements(db)
+		if err != nil {
+			return err
+		}
+		for _, m := range measurements {
+			names[m] = struct{}{}
+		}
+	}
+	measurements := make([]string, 0, len(names))
+	for m := range names {
+		measurements = append(measurements, m)
+	}
+	sort.Strings(measurements)
+
+	for _, rp := range rps {
+		for _, m := range measurements {
+			s, err := e.digest(ctx, src, rp.name, m)
+			if err != nil {
+				return err
+			}
+			d, err := e.digest(ctx, dst, rp.name, m)
+			if err != nil {
+				return err
+			}
+			if s.points != d.points {
+				return fmt.Errorf("measurement %s in %s: %d points in %s, %d in %s", m, rp.name, s.points, src, d.points, dst)
+			} else if s.checksum != d.checksum {
+				return fmt.Errorf("measurement %s in %s: points differ between %s and %s", m, rp.name, src, dst)
+			}
+		}
+	}
+	return nil
+}
+
+// digest returns the digest of measurement m in retention policy rp of database db.
+func (e *Exporter) digest(ctx context.Context, db, rp, m string) (measurementDigest, error) {
+	var d measurementDigest
+	err := e.eachPoint(ctx, db, rp, m, func(line string) error {
+		h := fnv.New64a()
+		h.Write([]byte(line))
+		d.points++
+		d.checksum += h.Sum64()
+		return nil
+	})
+	return d, err
+}
@@ -0,0 +1,34 @@
+package v8
+
+import "strings"
+
+// exportVersion is the header giving the version of the server an export
+// was made from. The Exporter writes it at the top of an export.
+const exportVersion = "# INFLUXDB-VERSION:"
+
+// checkVersion warns if l, an exportVersion header, gives a different
+// release than the one the export is being imported into: the version the
+// server reported, or the configured version if it wasn't pinged. Lines in
+// the format of another release may fail to parse.
+func (v8 *V8) checkVersion(l sourceLine) {
+	exported := strings.TrimSpace(strings.TrimPrefix(l.text, exportVersion))
+	target := v8.serverVersion
+	if target == "" {
+		target = v8.config.version
+	}
+	if exported == "" || target == "" || releaseOf(exported) == releaseOf(target) {
+		return
+	}
+	v8.logger().Printf("Warning: %s was exported from InfluxDB %s but is being imported into %s, "+
+		"lines that fail to parse may need the importer of that version\n", displayName(l.file), exported, target)
+}
+
+// releaseOf returns the major and minor version of v, such as "0.9" for
+// "v0.9.4", as the line protocol only changes between releases.
+func releaseOf(v string) string {
+	parts := strings.SplitN(strings.TrimPrefix(v, "v"), ".", 3)
+	if len(parts) > 2 {
+		parts = parts[:2]
+	}
+	return strings.Join(parts, ".")
+}
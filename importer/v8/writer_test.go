@@ -0,0 +1,295 @@
+package v8
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/client"
+)
+
+// Ensure that lines are written in batches of the batch size, to the context they were read in.
+func TestV8_writer_Batches(t *testing.T) {
+	w := &fakeWriter{}
+	v8 := newFakeV8(t, w, "# DML\n# CONTEXT-DATABASE:db0\ncpu value=1\ncpu value=2\ncpu value=3\n# CONTEXT-DATABASE:db1\ncpu value=4\n", 2)
+	if err := v8.Import(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := [][]string{{"cpu value=1", "cpu value=2"}, {"cpu value=3"}, {"cpu value=4"}}; !reflect.DeepEqual(w.Batches(), exp) {
+		t.Fatalf("unexpected batches: %#v", w.Batches())
+	}
+	if exp := []string{"db0", "db0", "db1"}; !reflect.DeepEqual(w.databases, exp) {
+		t.Fatalf("unexpected databases: %#v", w.databases)
+	}
+}
+
+// Ensure that a batch failing with a server error is retried until it is written.
+func TestV8_writer_Retry(t *testing.T) {
+	w := &fakeWriter{Fail: func(n int) bool { return n < 2 }}
+	v8 := newFakeV8(t, w, "# DML\n# CONTEXT-DATABASE:db0\ncpu value=1\n", 0)
+	v8.config.MaxRetries = 2
+	v8.config.RetryBackoff = time.Millisecond
+	if err := v8.Import(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.calls != 3 {
+		t.Fatalf("unexpected attempts: %d", w.calls)
+	}
+	if exp := [][]string{{"cpu value=1"}}; !reflect.DeepEqual(w.Batches(), exp) {
+		t.Fatalf("unexpected batches: %#v", w.Batches())
+	}
+	if s := v8.Summary(); s.TotalInserts != 1 || s.FailedInserts != 0 {
+		t.Fatalf("unexpected summary: %+v", s)
+	}
+}
+
+// Ensure that BeforeBatch and AfterBatch are called around every attempt at
+// writing a batch, including retries.
+func TestV8_writer_BatchHooks(t *testing.T) {
+	w := &fakeWriter{Fail: func(n int) bool { return n == 0 }}
+	v8 := newFakeV8(t, w, "# DML\n# CONTEXT-DATABASE:db0\ncpu value=1\ncpu value=2\ncpu value=3\n", 2)
+	v8.config.MaxRetries = 1
+	v8.config.RetryBackoff = time.Millisecond
+	var calls []string
+	v8.config.BeforeBatch = func(size int) {
+		calls = append(calls, fmt.Sprintf("before %d", size))
+	}
+	v8.config.AfterBatch = func(size int, err error, dur time.Duration) {
+		if dur <= 0 {
+			t.Errorf("unexpected duration: %s", dur)
+		}
+		calls = append(calls, fmt.Sprintf("after %d %v", size, err))
+	}
+	if err := v8.Import(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := []string{"before 2", "after 2 write failed", "before 2", "after 2 <nil>", "before 1", "after 1 <nil>"}
+	if !reflect.DeepEqual(calls, exp) {
+		t.Fatalf("unexpected calls:\n\nexp=%#v\n\ngot=%#v", exp, calls)
+	}
+}
+
+// Ensure that repeated lines are dropped from a batch before it is written.
+func TestV8_writer_Dedup(t *testing.T) {
+	w := &fakeWriter{}
+	v8 := newFakeV8(t, w, "# DML\n# CONTEXT-DATABASE:db0\ncpu value=1 1\ncpu value=2 2\ncpu value=1 1\n", 0)
+	v8.config.Dedup = true
+	if err := v8.Import(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := [][]string{{"cpu value=2 2", "cpu value=1 1"}}; !reflect.DeepEqual(w.Batches(), exp) {
+		t.Fatalf("unexpected batches: %#v", w.Batches())
+	}
+}
+
+// Ensure that retry delays fall within the bounds of each jitter strategy.
+func TestV8Config_retryDelay(t *testing.T) {
+	backoff := 100 * time.Millisecond
+	for _, tt := range []struct {
+		jitter   string
+		min, max time.Duration
+	}{
+		{jitter: JitterFull, min: 0, max: backoff},
+		{jitter: JitterEqual, min: backoff / 2, max: backoff},
+		{jitter: JitterNone, min: backoff, max: backoff},
+	} {
+		c := &V8Config{RetryJitter: tt.jitter}
+		seen := make(map[time.Duration]bool)
+		for i := 0; i < 1000; i++ {
+			d := c.retryDelay(backoff)
+			if d < tt.min || d > tt.max {
+				t.Fatalf("%q: delay %s outside %s to %s", tt.jitter, d, tt.min, tt.max)
+			}
+			seen[d] = true
+		}
+		if tt.min != tt.max && len(seen) < 2 {
+			t.Fatalf("%q: delay isn't random", tt.jitter)
+		}
+	}
+}
+
+// Ensure that waiting for the rate limit stops when the context is cancelled.
+func TestLimiter_wait_Cancelled(t *testing.T) {
+	l := newLimiter(1)
+	l.wait(context.Background(), 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	l.wait(ctx, 1)
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("unexpected wait after cancelling: %s", d)
+	}
+}
+
+// Ensure that the remaining time is only estimated once the rate has settled,
+// and follows changes in the rate gradually.
+func TestETAEstimator_remaining(t *testing.T) {
+	start := time.Unix(0, 0)
+	if d := newETAEstimator(start, 0).remaining(start.Add(time.Minute), 1000); d != 0 {
+		t.Fatalf("unexpected estimate without a size: %s", d)
+	}
+
+	// 10kB a second for ten seconds, then 5kB a second
+	e := newETAEstimator(start, 1000000)
+	var n int64
+	for i := 1; i <= 10; i++ {
+		n += 10000
+		if d := e.remaining(start.Add(time.Duration(i)*time.Second), n); i < 10 && d != 0 {
+			t.Fatalf("unexpected estimate during warm up: %s", d)
+		} else if i == 10 && d != 90*time.Second {
+			t.Fatalf("unexpected estimate: %s", d)
+		}
+	}
+	// Reports in between samples don't move the rate
+	if d := e.remaining(start.Add(10*time.Second+time.Millisecond), n+5000); d != 90*time.Second {
+		t.Fatalf("unexpected estimate between samples: %s", d)
+	}
+	var d time.Duration
+	for i := 11; i <= 40; i++ {
+		n += 5000
+		d = e.remaining(start.Add(time.Duration(i)*time.Second), n)
+	}
+	if fast, slow := time.Duration(1000000-n)*time.Second/10000, time.Duration(1000000-n)*time.Second/5000; d <= fast || d >= slow {
+		t.Fatalf("estimate %s outside %s to %s", d, fast, slow)
+	}
+
+	if d := e.remaining(start.Add(time.Hour), 1000000); d != 0 {
+		t.Fatalf("unexpected estimate once read: %s", d)
+	}
+}
+
+// Ensure that the lines kept in batches are copies, which reading more of the
+// file into the scanner's buffer doesn't change.
+func TestV8_writer_LinesNotAliased(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("# DML\n# CONTEXT-DATABASE:db0\n")
+	var exp []string
+	for i := 0; i < 2000; i++ {
+		l := fmt.Sprintf("cpu,host=server%04d value=%d %d", i, i, i)
+		exp = append(exp, l)
+		buf.WriteString(l + " \r\n")
+	}
+	w := &fakeWriter{}
+	v8 := newFakeV8(t, w, buf.String(), 1000)
+	if err := v8.Import(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, b := range w.Batches() {
+		got = append(got, b...)
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected lines: %d written, %d expected", len(got), len(exp))
+	}
+}
+
+// lineSink keeps benchmarked lines from being optimised away.
+var lineSink []string
+
+// Benchmark reading lines as the scanner's Text and trimming them, against
+// measuring its Bytes and copying only the trimmed line, as lineScanner does.
+func BenchmarkLineScanner(b *testing.B) {
+	var buf bytes.Buffer
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&buf, "cpu,host=server%02d value=%d 1434055562000000000\n", i%100, i)
+	}
+	content := buf.Bytes()
+	batch := make([]string, 0, defaultBatchSize)
+
+	b.Run("Text", func(b *testing.B) {
+		b.SetBytes(int64(len(content)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			s := bufio.NewScanner(bytes.NewReader(content))
+			for batch = batch[:0]; s.Scan(); {
+				if len(strings.TrimSuffix(s.Text(), "\r")) > defaultMaxLineBytes {
+					b.Fatal("line too long")
+				}
+				batch = append(batch, strings.TrimRight(s.Text(), " \t\r"))
+			}
+			lineSink = batch
+		}
+	})
+	b.Run("Bytes", func(b *testing.B) {
+		b.SetBytes(int64(len(content)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			s := newLineScanner(bytes.NewReader(content), "bench", defaultMaxLineBytes)
+			for batch = batch[:0]; s.Scan(); {
+				batch = append(batch, s.Text())
+			}
+			lineSink = batch
+		}
+	})
+}
+
+// fakeWriter is a lineWriter that records the batches written to it.
+type fakeWriter struct {
+	mu        sync.Mutex
+	calls     int
+	batches   [][]string
+	databases []string
+
+	// Fail, if set, makes the nth write fail with a server error.
+	Fail func(n int) bool
+}
+
+// WriteLines records lines as a batch, unless the write is to fail.
+func (w *fakeWriter) WriteLines(lines []string, database, retentionPolicy, precision, writeConsistency string) (*client.Response, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := w.calls
+	w.calls++
+	if w.Fail != nil && w.Fail(n) {
+		return &client.Response{StatusCode: http.StatusInternalServerError}, errors.New("write failed")
+	}
+	w.batches = append(w.batches, append([]string(nil), lines...))
+	w.databases = append(w.databases, database)
+	return nil, nil
+}
+
+// Batches returns the batches written so far.
+func (w *fakeWriter) Batches() [][]string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([][]string(nil), w.batches...)
+}
+
+// newFakeV8 returns an importer for content that writes to w. The server it
+// connects to only answers pings and queries.
+func newFakeV8(t *testing.T, w lineWriter, content string, batchSize int) *V8 {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/query" {
+			rw.Write([]byte(`{"results":[{}]}`))
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(ts.Close)
+
+	f, err := ioutil.TempFile("", "influxdb-v8-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(content)
+	f.Close()
+	t.Cleanup(func() { os.Remove(f.Name()) })
+
+	u, _ := url.Parse(ts.URL)
+	v8 := NewV8(NewV8Config("", "", "", "", f.Name(), "test", *u, false, batchSize))
+	v8.writer = w
+	return v8
+}